@@ -0,0 +1,4 @@
+// Package api contains gRPC service of the matchmaker generated from matchmaker.proto
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative matchmaker.proto
@@ -0,0 +1,367 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: matchmaker.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Matchmaker_CreateTicket_FullMethodName      = "/goplay.matchmaker.v1.Matchmaker/CreateTicket"
+	Matchmaker_CancelTicket_FullMethodName      = "/goplay.matchmaker.v1.Matchmaker/CancelTicket"
+	Matchmaker_GetTicket_FullMethodName         = "/goplay.matchmaker.v1.Matchmaker/GetTicket"
+	Matchmaker_WatchTicket_FullMethodName       = "/goplay.matchmaker.v1.Matchmaker/WatchTicket"
+	Matchmaker_AcceptMatch_FullMethodName       = "/goplay.matchmaker.v1.Matchmaker/AcceptMatch"
+	Matchmaker_DeclineMatch_FullMethodName      = "/goplay.matchmaker.v1.Matchmaker/DeclineMatch"
+	Matchmaker_ReportMatchResult_FullMethodName = "/goplay.matchmaker.v1.Matchmaker/ReportMatchResult"
+)
+
+// MatchmakerClient is the client API for Matchmaker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MatchmakerClient interface {
+	// Puts the group of players into search, the ticket is returned at once
+	CreateTicket(ctx context.Context, in *CreateTicketRequest, opts ...grpc.CallOption) (*Ticket, error)
+	CancelTicket(ctx context.Context, in *CancelTicketRequest, opts ...grpc.CallOption) (*Ticket, error)
+	GetTicket(ctx context.Context, in *GetTicketRequest, opts ...grpc.CallOption) (*Ticket, error)
+	// Sends the ticket and each change of it until the ticket is finished
+	WatchTicket(ctx context.Context, in *WatchTicketRequest, opts ...grpc.CallOption) (Matchmaker_WatchTicketClient, error)
+	// Answers ready check of the match the player is in
+	AcceptMatch(ctx context.Context, in *AcceptMatchRequest, opts ...grpc.CallOption) (*AcceptMatchResponse, error)
+	DeclineMatch(ctx context.Context, in *DeclineMatchRequest, opts ...grpc.CallOption) (*DeclineMatchResponse, error)
+	// Records the result, so players' next matches take it into account
+	ReportMatchResult(ctx context.Context, in *ReportMatchResultRequest, opts ...grpc.CallOption) (*ReportMatchResultResponse, error)
+}
+
+type matchmakerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchmakerClient(cc grpc.ClientConnInterface) MatchmakerClient {
+	return &matchmakerClient{cc}
+}
+
+func (c *matchmakerClient) CreateTicket(ctx context.Context, in *CreateTicketRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Matchmaker_CreateTicket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) CancelTicket(ctx context.Context, in *CancelTicketRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Matchmaker_CancelTicket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) GetTicket(ctx context.Context, in *GetTicketRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Matchmaker_GetTicket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) WatchTicket(ctx context.Context, in *WatchTicketRequest, opts ...grpc.CallOption) (Matchmaker_WatchTicketClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Matchmaker_ServiceDesc.Streams[0], Matchmaker_WatchTicket_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &matchmakerWatchTicketClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Matchmaker_WatchTicketClient interface {
+	Recv() (*Ticket, error)
+	grpc.ClientStream
+}
+
+type matchmakerWatchTicketClient struct {
+	grpc.ClientStream
+}
+
+func (x *matchmakerWatchTicketClient) Recv() (*Ticket, error) {
+	m := new(Ticket)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *matchmakerClient) AcceptMatch(ctx context.Context, in *AcceptMatchRequest, opts ...grpc.CallOption) (*AcceptMatchResponse, error) {
+	out := new(AcceptMatchResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_AcceptMatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) DeclineMatch(ctx context.Context, in *DeclineMatchRequest, opts ...grpc.CallOption) (*DeclineMatchResponse, error) {
+	out := new(DeclineMatchResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_DeclineMatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) ReportMatchResult(ctx context.Context, in *ReportMatchResultRequest, opts ...grpc.CallOption) (*ReportMatchResultResponse, error) {
+	out := new(ReportMatchResultResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_ReportMatchResult_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MatchmakerServer is the server API for Matchmaker service.
+// All implementations must embed UnimplementedMatchmakerServer
+// for forward compatibility
+type MatchmakerServer interface {
+	// Puts the group of players into search, the ticket is returned at once
+	CreateTicket(context.Context, *CreateTicketRequest) (*Ticket, error)
+	CancelTicket(context.Context, *CancelTicketRequest) (*Ticket, error)
+	GetTicket(context.Context, *GetTicketRequest) (*Ticket, error)
+	// Sends the ticket and each change of it until the ticket is finished
+	WatchTicket(*WatchTicketRequest, Matchmaker_WatchTicketServer) error
+	// Answers ready check of the match the player is in
+	AcceptMatch(context.Context, *AcceptMatchRequest) (*AcceptMatchResponse, error)
+	DeclineMatch(context.Context, *DeclineMatchRequest) (*DeclineMatchResponse, error)
+	// Records the result, so players' next matches take it into account
+	ReportMatchResult(context.Context, *ReportMatchResultRequest) (*ReportMatchResultResponse, error)
+	mustEmbedUnimplementedMatchmakerServer()
+}
+
+// UnimplementedMatchmakerServer must be embedded to have forward compatible implementations.
+type UnimplementedMatchmakerServer struct {
+}
+
+func (UnimplementedMatchmakerServer) CreateTicket(context.Context, *CreateTicketRequest) (*Ticket, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) CancelTicket(context.Context, *CancelTicketRequest) (*Ticket, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) GetTicket(context.Context, *GetTicketRequest) (*Ticket, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) WatchTicket(*WatchTicketRequest, Matchmaker_WatchTicketServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) AcceptMatch(context.Context, *AcceptMatchRequest) (*AcceptMatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcceptMatch not implemented")
+}
+func (UnimplementedMatchmakerServer) DeclineMatch(context.Context, *DeclineMatchRequest) (*DeclineMatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeclineMatch not implemented")
+}
+func (UnimplementedMatchmakerServer) ReportMatchResult(context.Context, *ReportMatchResultRequest) (*ReportMatchResultResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportMatchResult not implemented")
+}
+func (UnimplementedMatchmakerServer) mustEmbedUnimplementedMatchmakerServer() {}
+
+// UnsafeMatchmakerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchmakerServer will
+// result in compilation errors.
+type UnsafeMatchmakerServer interface {
+	mustEmbedUnimplementedMatchmakerServer()
+}
+
+func RegisterMatchmakerServer(s grpc.ServiceRegistrar, srv MatchmakerServer) {
+	s.RegisterService(&Matchmaker_ServiceDesc, srv)
+}
+
+func _Matchmaker_CreateTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).CreateTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_CreateTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).CreateTicket(ctx, req.(*CreateTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_CancelTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).CancelTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_CancelTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).CancelTicket(ctx, req.(*CancelTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_GetTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).GetTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_GetTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).GetTicket(ctx, req.(*GetTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_WatchTicket_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTicketRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchmakerServer).WatchTicket(m, &matchmakerWatchTicketServer{stream})
+}
+
+type Matchmaker_WatchTicketServer interface {
+	Send(*Ticket) error
+	grpc.ServerStream
+}
+
+type matchmakerWatchTicketServer struct {
+	grpc.ServerStream
+}
+
+func (x *matchmakerWatchTicketServer) Send(m *Ticket) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Matchmaker_AcceptMatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AcceptMatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).AcceptMatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_AcceptMatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).AcceptMatch(ctx, req.(*AcceptMatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_DeclineMatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeclineMatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).DeclineMatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_DeclineMatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).DeclineMatch(ctx, req.(*DeclineMatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_ReportMatchResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportMatchResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).ReportMatchResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_ReportMatchResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).ReportMatchResult(ctx, req.(*ReportMatchResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Matchmaker_ServiceDesc is the grpc.ServiceDesc for Matchmaker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Matchmaker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goplay.matchmaker.v1.Matchmaker",
+	HandlerType: (*MatchmakerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateTicket",
+			Handler:    _Matchmaker_CreateTicket_Handler,
+		},
+		{
+			MethodName: "CancelTicket",
+			Handler:    _Matchmaker_CancelTicket_Handler,
+		},
+		{
+			MethodName: "GetTicket",
+			Handler:    _Matchmaker_GetTicket_Handler,
+		},
+		{
+			MethodName: "AcceptMatch",
+			Handler:    _Matchmaker_AcceptMatch_Handler,
+		},
+		{
+			MethodName: "DeclineMatch",
+			Handler:    _Matchmaker_DeclineMatch_Handler,
+		},
+		{
+			MethodName: "ReportMatchResult",
+			Handler:    _Matchmaker_ReportMatchResult_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTicket",
+			Handler:       _Matchmaker_WatchTicket_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "matchmaker.proto",
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// Token is signed by key the authenticator doesn't know, another authenticator may know it
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrNoToken    = errors.New("no token")
+)
+
+// Who makes the request: a service like lobby or a player
+type Caller struct {
+	// Name of the service, empty if the caller is a player
+	Service  string
+	PlayerID int
+}
+
+func (c *Caller) IsService() bool {
+	return c.Service != ""
+}
+
+// Verifies bearer token and returns its caller
+type Authenticator interface {
+	Authenticate(token string) (*Caller, error)
+}
+
+// Tries authenticators in order until one of them knows the key of the token
+type Chain []Authenticator
+
+func (c Chain) Authenticate(token string) (*Caller, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	for _, authenticator := range c {
+		caller, err := authenticator.Authenticate(token)
+		if !errors.Is(err, ErrUnknownKey) {
+			return caller, err
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+type callerKey struct{}
+
+func NewContext(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// Returns caller of the request, false if authentication is disabled
+func FromContext(ctx context.Context) (*Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(*Caller)
+	return caller, ok
+}
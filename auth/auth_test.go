@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func encode(v any) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func sign(alg, kid string, claims map[string]any, key any) string {
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, hash[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func TestAuthenticate(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeInt(rsaKey.N), "e": encodeInt(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeInt(ecKey.X), "y": encodeInt(ecKey.Y)},
+	}}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(jwks)
+	os.WriteFile(path, data, 0o644)
+
+	players, err := NewPlayerAuthenticator(path, "auth-server", "goplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("lobby-secret")
+	authenticator := Chain{NewServiceAuthenticator(map[string]string{"lobby": string(secret)}, "goplay"), players}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	player := map[string]any{"sub": "42", "iss": "auth-server", "aud": "goplay", "exp": exp}
+	for _, tc := range []struct {
+		name   string
+		token  string
+		caller *Caller
+	}{
+		{"service", sign("HS256", "lobby", map[string]any{"aud": []string{"goplay"}, "exp": exp}, secret), &Caller{Service: "lobby"}},
+		{"wrong secret", sign("HS256", "lobby", map[string]any{"aud": "goplay", "exp": exp}, []byte("guess")), nil},
+		{"service without audience", sign("HS256", "lobby", map[string]any{"exp": exp}, secret), nil},
+		{"RSA player", sign("RS256", "rsa", player, rsaKey), &Caller{PlayerID: 42}},
+		{"EC player", sign("ES256", "ec", player, ecKey), &Caller{PlayerID: 42}},
+		{"algorithm of other key", sign("ES256", "rsa", player, ecKey), nil},
+		{"unknown key", sign("HS256", "admin", player, secret), nil},
+		{"expired", sign("RS256", "rsa", map[string]any{"sub": "42", "iss": "auth-server", "aud": "goplay",
+			"exp": time.Now().Add(-time.Minute).Unix()}, rsaKey), nil},
+		{"other issuer", sign("RS256", "rsa", map[string]any{"sub": "42", "iss": "other", "aud": "goplay", "exp": exp}, rsaKey), nil},
+		{"subject is not player", sign("RS256", "rsa", map[string]any{"sub": "lobby", "iss": "auth-server", "aud": "goplay", "exp": exp}, rsaKey), nil},
+		{"malformed", "token", nil},
+	} {
+		caller, err := authenticator.Authenticate(tc.token)
+		if tc.caller == nil {
+			if err == nil {
+				t.Errorf("%s: got caller %v, want error", tc.name, caller)
+			}
+			continue
+		}
+		if err != nil || *caller != *tc.caller {
+			t.Errorf("%s: got caller %v, error %v, want %v", tc.name, caller, err, tc.caller)
+		}
+	}
+
+	_, err = authenticator.Authenticate("")
+	if !errors.Is(err, ErrNoToken) {
+		t.Errorf("got error %v without token, want %v", err, ErrNoToken)
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Allowed difference between clocks of the token issuer and the matchmaker
+const clockSkew = 30 * time.Second
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Either a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+type claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// JWT in compact form, its signature is not verified yet
+type token struct {
+	header    header
+	claims    claims
+	signed    []byte
+	signature []byte
+}
+
+func parseToken(s string) (*token, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var t token
+	if err := decodePart(parts[0], &t.header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if err := decodePart(parts[1], &t.claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+	t.signature = signature
+
+	return &t, nil
+}
+
+func decodePart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Checks time claims, audience and issuer if they are set
+func (c *claims) validate(now time.Time, issuer, aud string) error {
+	if c.ExpiresAt == 0 {
+		return errors.New("token has no expiration time")
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("token is expired")
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("token is issued by %q", c.Issuer)
+	}
+	if aud == "" {
+		return nil
+	}
+	for _, a := range c.Audience {
+		if a == aud {
+			return nil
+		}
+	}
+
+	return errors.New("token is not intended for the matchmaker")
+}
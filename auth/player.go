@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Key of JWKS file
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Authenticates players by tokens of the auth server signed with RS256 or ES256.
+// Public keys are read from JWKS file, subject of the token is player ID.
+type PlayerAuthenticator struct {
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func NewPlayerAuthenticator(jwksFile, issuer, audience string) (*PlayerAuthenticator, error) {
+	data, err := os.ReadFile(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks file: %w", err)
+	}
+
+	a := &PlayerAuthenticator{
+		keys:     make(map[string]crypto.PublicKey),
+		issuer:   issuer,
+		audience: audience,
+		now:      time.Now,
+	}
+	for _, key := range jwks.Keys {
+		// Keys for encryption are not used
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.Kid, err)
+		}
+		a.keys[key.Kid] = publicKey
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("jwks file has no signing keys")
+	}
+
+	return a, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (a *PlayerAuthenticator) Authenticate(s string) (*Caller, error) {
+	t, err := parseToken(s)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := a.keys[t.header.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if err := verifySignature(t, key); err != nil {
+		return nil, err
+	}
+	if err := t.claims.validate(a.now(), a.issuer, a.audience); err != nil {
+		return nil, err
+	}
+	playerID, err := strconv.Atoi(t.claims.Subject)
+	if err != nil || playerID <= 0 {
+		return nil, fmt.Errorf("subject %q is not a player ID", t.claims.Subject)
+	}
+
+	return &Caller{PlayerID: playerID}, nil
+}
+
+// Algorithm must match the key, so a public key can't be used as HMAC secret
+func verifySignature(t *token, key crypto.PublicKey) error {
+	hash := sha256.Sum256(t.signed)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if t.header.Alg != "RS256" {
+			return fmt.Errorf("algorithm %s doesn't match RSA key", t.header.Alg)
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], t.signature) != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if t.header.Alg != "ES256" {
+			return fmt.Errorf("algorithm %s doesn't match EC key", t.header.Alg)
+		}
+		// Signature is r and s of 32 bytes each
+		if len(t.signature) != 64 {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return errors.New("invalid token signature")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backend which grants leases of shards to instances.
+// Lease is held until it expires or is released, the owner renews it before it expires.
+type Coordinator interface {
+	// Takes lease of the shard for the instance if the shard is free or renews it if the instance holds it.
+	// Returns the current owner of the shard.
+	Acquire(ctx context.Context, shard, instance string, ttl time.Duration) (string, error)
+	// Returns the current owner of the shard, empty if nobody holds it
+	Owner(ctx context.Context, shard string) (string, error)
+	Release(ctx context.Context, shard, instance string) error
+}
+
+type lease struct {
+	owner   string
+	expires time.Time
+}
+
+// Keeps leases in memory, so instances in one process can share it, e.g. in tests
+type LocalCoordinator struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+func NewLocalCoordinator() *LocalCoordinator {
+	return &LocalCoordinator{
+		leases: make(map[string]lease),
+	}
+}
+
+func (c *LocalCoordinator) Acquire(ctx context.Context, shard, instance string, ttl time.Duration) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	current, ok := c.leases[shard]
+	if !ok || current.owner == instance || current.expires.Before(now) {
+		current = lease{owner: instance, expires: now.Add(ttl)}
+		c.leases[shard] = current
+	}
+
+	return current.owner, nil
+}
+
+func (c *LocalCoordinator) Owner(ctx context.Context, shard string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.leases[shard]
+	if !ok || current.expires.Before(time.Now()) {
+		return "", nil
+	}
+
+	return current.owner, nil
+}
+
+func (c *LocalCoordinator) Release(ctx context.Context, shard, instance string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leases[shard].owner == instance {
+		delete(c.leases, shard)
+	}
+
+	return nil
+}
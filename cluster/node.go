@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNoOwner = errors.New("no instance owns the shard")
+
+type Option func(n *Node)
+
+// Restricts shards the instance takes leases of, it only forwards requests of the others
+func WithShards(shards []string) Option {
+	return func(n *Node) {
+		if len(shards) == 0 {
+			return
+		}
+		n.candidates = make(map[string]bool)
+		for _, shard := range shards {
+			n.candidates[shard] = true
+		}
+	}
+}
+
+// Called when the instance takes or loses lease of the shard
+func OnOwnershipChange(f func(shard string, owned bool)) Option {
+	return func(n *Node) {
+		n.onChange = f
+	}
+}
+
+// Secret shared by instances, it signs requests they send to each other
+func WithSecret(secret string) Option {
+	return func(n *Node) {
+		n.secret = []byte(secret)
+	}
+}
+
+// Instance of the matchmaker in the cluster. Each shard (queue) is owned by one instance,
+// which holds its lease, other instances forward requests of the shard to it.
+type Node struct {
+	// Address other instances reach this one by, also ID of the instance
+	addr        string
+	coordinator Coordinator
+	shards      []string
+	// Shards the instance takes leases of, nil if all
+	candidates map[string]bool
+	ttl        time.Duration
+	onChange   func(shard string, owned bool)
+	secret     []byte
+
+	mu     sync.Mutex
+	owners map[string]string
+	// When leases held by the instance expire
+	expires map[string]time.Time
+}
+
+func NewNode(addr string, coordinator Coordinator, shards []string, ttl time.Duration, opts ...Option) *Node {
+	n := &Node{
+		addr:        addr,
+		coordinator: coordinator,
+		shards:      shards,
+		ttl:         ttl,
+		onChange:    func(shard string, owned bool) {},
+		owners:      make(map[string]string),
+		expires:     make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	return n
+}
+
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Signs the message with the secret of the cluster, so other instances can tell it from messages of clients
+func (n *Node) Sign(message []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(message)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks signature of the message made by any instance of the cluster, always fails without secret
+func (n *Node) Verify(message []byte, signature string) bool {
+	if len(n.secret) == 0 {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Returns address of the instance which owns the shard, as it was known at the last refresh
+func (n *Node) Owner(shard string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	owner := n.owners[shard]
+	if owner == "" {
+		return "", ErrNoOwner
+	}
+
+	return owner, nil
+}
+
+func (n *Node) Owns(shard string) bool {
+	owner, _ := n.Owner(shard)
+	return owner == n.addr
+}
+
+// Returns addresses of other instances which own shards
+func (n *Node) Peers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var peers []string
+	for _, owner := range n.owners {
+		if owner != "" && owner != n.addr && !seen[owner] {
+			seen[owner] = true
+			peers = append(peers, owner)
+		}
+	}
+	sort.Strings(peers)
+
+	return peers
+}
+
+// Renews leases until context is cancelled, then releases them
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		n.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			n.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Takes or renews leases of shards and updates their owners
+func (n *Node) Refresh(ctx context.Context) {
+	for _, shard := range n.shards {
+		var owner string
+		var err error
+		// Lease is counted from the request, so the instance doesn't think it holds it longer than it does
+		start := time.Now()
+		if n.candidates == nil || n.candidates[shard] {
+			owner, err = n.coordinator.Acquire(ctx, shard, n.addr, n.ttl)
+		} else {
+			owner, err = n.coordinator.Owner(ctx, shard)
+		}
+
+		if err != nil {
+			slog.WarnContext(ctx, "failed to refresh shard lease", "shard", shard, "error", err)
+			n.mu.Lock()
+			owned := n.owners[shard] == n.addr
+			// Lease which can't be renewed is given up before the next refresh could be too late,
+			// so another instance doesn't take it while this one still matches groups
+			expired := owned && time.Now().Add(n.ttl/3).After(n.expires[shard])
+			n.mu.Unlock()
+			if expired {
+				n.setOwner(shard, "", time.Time{})
+			}
+			continue
+		}
+
+		n.setOwner(shard, owner, start.Add(n.ttl))
+	}
+}
+
+func (n *Node) setOwner(shard, owner string, expires time.Time) {
+	n.mu.Lock()
+	previous := n.owners[shard]
+	n.owners[shard] = owner
+	if owner == n.addr {
+		n.expires[shard] = expires
+	} else {
+		delete(n.expires, shard)
+	}
+	n.mu.Unlock()
+
+	if previous == owner {
+		return
+	}
+	slog.Info("shard owner changed", "shard", shard, "owner", owner, "previous_owner", previous)
+	if owner == n.addr {
+		n.onChange(shard, true)
+	} else if previous == n.addr {
+		n.onChange(shard, false)
+	}
+}
+
+func (n *Node) release() {
+	for _, shard := range n.shards {
+		if !n.Owns(shard) {
+			continue
+		}
+
+		n.setOwner(shard, "", time.Time{})
+		err := n.coordinator.Release(context.Background(), shard, n.addr)
+		if err != nil {
+			slog.Warn("failed to release shard lease", "shard", shard, "error", err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeaseFailover(t *testing.T) {
+	coordinator := NewLocalCoordinator()
+	changes := make(map[string]bool)
+	first := NewNode("http://first", coordinator, []string{"a", "b"}, 50*time.Millisecond)
+	second := NewNode("http://second", coordinator, []string{"a", "b"}, 50*time.Millisecond, WithShards([]string{"b"}),
+		OnOwnershipChange(func(shard string, owned bool) {
+			changes[shard] = owned
+		}))
+
+	first.Refresh(context.Background())
+	second.Refresh(context.Background())
+	if !first.Owns("a") || !first.Owns("b") || second.Owns("b") {
+		t.Fatal("first instance doesn't own free shards")
+	}
+	if owner, _ := second.Owner("a"); owner != "http://first" {
+		t.Errorf("got owner %q of shard a, want first instance", owner)
+	}
+	if peers := second.Peers(); len(peers) != 1 || peers[0] != "http://first" {
+		t.Errorf("got peers %v, want first instance", peers)
+	}
+
+	// First instance stops renewing leases
+	time.Sleep(60 * time.Millisecond)
+	second.Refresh(context.Background())
+	if !second.Owns("b") || !changes["b"] {
+		t.Error("second instance didn't take expired lease")
+	}
+	if _, err := second.Owner("a"); err != ErrNoOwner {
+		t.Errorf("got %v, want %v for shard which is not taken by second instance", err, ErrNoOwner)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	second.Run(ctx)
+	if changes["b"] {
+		t.Error("lease is not released when instance stops")
+	}
+	if owner, _ := coordinator.Owner(context.Background(), "b"); owner != "" {
+		t.Errorf("got owner %q, want released lease", owner)
+	}
+}
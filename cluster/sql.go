@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Keeps leases in the table shared by all instances:
+//
+//	CREATE TABLE matchmaker_leases (shard TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_at TIMESTAMP NOT NULL)
+//
+// Expiration time is set by clock of the instance, so clocks of instances must be in sync
+// with precision much better than lease TTL.
+type SQLCoordinator struct {
+	db *sql.DB
+}
+
+func NewSQLCoordinator(db *sql.DB) *SQLCoordinator {
+	return &SQLCoordinator{
+		db: db,
+	}
+}
+
+func (c *SQLCoordinator) Acquire(ctx context.Context, shard, instance string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	res, err := c.db.ExecContext(ctx, `UPDATE matchmaker_leases SET owner = ?, expires_at = ? WHERE shard = ? AND (owner = ? OR expires_at < ?)`,
+		instance, now.Add(ttl), shard, instance, now)
+	if err != nil {
+		return "", err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if updated == 0 {
+		// Fails on duplicate key if another instance holds the lease, the owner is read below anyway
+		_, err = c.db.ExecContext(ctx, `INSERT INTO matchmaker_leases (shard, owner, expires_at) VALUES (?, ?, ?)`,
+			shard, instance, now.Add(ttl))
+		if err != nil && !isUniqueViolation(err) {
+			return "", err
+		}
+	}
+
+	return c.Owner(ctx, shard)
+}
+
+// Postgres drivers report SQLSTATE of the error, other drivers only tell it in the message
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate") || strings.Contains(msg, "unique constraint")
+}
+
+func (c *SQLCoordinator) Owner(ctx context.Context, shard string) (string, error) {
+	var owner string
+	err := c.db.QueryRowContext(ctx, `SELECT owner FROM matchmaker_leases WHERE shard = ? AND expires_at > ?`,
+		shard, time.Now().UTC()).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return owner, err
+}
+
+func (c *SQLCoordinator) Release(ctx context.Context, shard, instance string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM matchmaker_leases WHERE shard = ? AND owner = ?`, shard, instance)
+	return err
+}
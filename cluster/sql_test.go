@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Database without free leases, which fails inserts with the given error and reads the given owner
+type testDB struct {
+	insertErr error
+	owner     string
+}
+
+func (d *testDB) Connect(ctx context.Context) (driver.Conn, error) { return testConn{d}, nil }
+func (d *testDB) Driver() driver.Driver                            { return nil }
+
+type testConn struct{ db *testDB }
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) { return testStmt{c.db, query}, nil }
+func (c testConn) Close() error                              { return nil }
+func (c testConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type testStmt struct {
+	db    *testDB
+	query string
+}
+
+func (s testStmt) Close() error  { return nil }
+func (s testStmt) NumInput() int { return -1 }
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(s.query, "INSERT") {
+		return nil, s.db.insertErr
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &testRows{owner: s.db.owner}, nil
+}
+
+type testRows struct {
+	owner string
+	read  bool
+}
+
+func (r *testRows) Columns() []string { return []string{"owner"} }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	dest[0] = r.owner
+	r.read = true
+	return nil
+}
+
+type pgError struct{ code string }
+
+func (e pgError) Error() string    { return "pq: error " + e.code }
+func (e pgError) SQLState() string { return e.code }
+
+func TestSQLCoordinatorAcquire(t *testing.T) {
+	tests := []struct {
+		insertErr error
+		wantErr   bool
+	}{
+		{pgError{"23505"}, false},
+		{errors.New("Error 1062: Duplicate entry 'a' for key 'PRIMARY'"), false},
+		{errors.New("UNIQUE constraint failed: matchmaker_leases.shard"), false},
+		{pgError{"40001"}, true},
+		{errors.New("connection reset by peer"), true},
+	}
+
+	for _, test := range tests {
+		coordinator := NewSQLCoordinator(sql.OpenDB(&testDB{insertErr: test.insertErr, owner: "other"}))
+		owner, err := coordinator.Acquire(context.Background(), "a", "self", time.Minute)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v: error is ignored", test.insertErr)
+			}
+			continue
+		}
+		if err != nil || owner != "other" {
+			t.Errorf("%v: got owner %q, error %v, want lease of the other instance", test.insertErr, owner, err)
+		}
+	}
+}
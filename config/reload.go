@@ -0,0 +1,65 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reloads config with the same args when config files change or the process receives SIGHUP
+// and applies new config. Invalid config is logged and ignored, so the previous one stays in effect.
+func WatchConfig(args []string, cfg *Config, apply func(cfg *Config)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(cfg.Server.ConfigReloadInterval.Duration)
+	defer ticker.Stop()
+
+	paths := []string{cfg.File, cfg.MatchmakerPath}
+	lastModified := modTimes(paths)
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+			modified := modTimes(paths)
+			if modified == lastModified {
+				continue
+			}
+			lastModified = modified
+		}
+
+		newCfg, err := NewConfig(args)
+		if err != nil {
+			slog.Error("config is not reloaded", "error", err)
+			continue
+		}
+
+		apply(newCfg)
+		slog.Info("config reloaded")
+	}
+}
+
+// Modification times of files joined to compare them at once
+func modTimes(paths []string) string {
+	var times []string
+	for _, path := range paths {
+		if path != "" {
+			times = append(times, modTime(path).String())
+		}
+	}
+
+	return strings.Join(times, ",")
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
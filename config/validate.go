@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Checks that config describes a match format which can be assembled.
+// All problems are returned at once.
+func (c *MatchmakerConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	slots := c.TeamSlots()
+	check(len(slots) > 0, "teamCount, maxTeamCount or teams must be set")
+	for i, slot := range slots {
+		check(slot.Size > 0, "team %d: size must be positive", i)
+		check(slot.MinSize >= 0 && slot.MinSize <= slot.Size, "team %d: minSize must be between 0 and size", i)
+	}
+	check(c.MinTeamCount >= 0 && c.MinTeamCount <= len(slots), "minTeamCount must be between 0 and the number of teams")
+	check(c.MinTeamSize >= 0, "minTeamSize must not be negative")
+	check(c.MaxTeamSize == 0 || c.MinTeamSize <= c.MaxTeamSize, "minTeamSize must not exceed maxTeamSize")
+	check(c.SecondsToStartPartialMatch >= 0, "secondsToStartPartialMatch must not be negative")
+	check(c.PlayersToStartPartialMatch >= 0, "playersToStartPartialMatch must not be negative")
+
+	check(c.MaxRatingSpreadToSearch >= 0, "maxRatingSpreadToSearch must not be negative")
+	check(c.MaxRatingSpreadInGroup >= -1, "maxRatingSpreadInGroup must be -1 or more")
+	check(!c.CheckReadiness || c.SecondsToAcceptMatch > 0, "secondsToAcceptMatch must be positive when checkReadiness is set")
+	check(!c.PenaltyForUnacceptedMatch || c.PenaltySeconds > 0, "penaltySeconds must be positive when penaltyForUnacceptedMatch is set")
+	check(c.MaxSecondsToSeed >= 0, "maxSecondsToSeed must not be negative")
+	check(c.SecondsToRoleFallback >= 0, "secondsToRoleFallback must not be negative")
+
+	if len(c.Roles) > 0 {
+		sum := 0
+		for role, count := range c.Roles {
+			check(count > 0, "roles: count of %q must be positive", role)
+			sum += count
+		}
+		// Composition applies to teams without a required role
+		for i, slot := range slots {
+			check(slot.Role != "" || sum == slot.Size, "roles: counts must sum up to size of team %d", i)
+		}
+	}
+
+	for i, rule := range c.AdmissionRules {
+		switch rule.Type {
+		case AdmissionMaxRatingSpread, AdmissionMaxPartyRating, AdmissionMinAccountLevel:
+		case AdmissionTopRankPartySize:
+			check(rule.TopRank > 0, "admissionRules %d: topRank must be positive", i)
+		default:
+			check(false, "admissionRules %d: unknown type %q", i, rule.Type)
+		}
+	}
+
+	if c.PartyFairness != nil {
+		check(c.PartyFairness.MaxPartySizeDelta == nil || *c.PartyFairness.MaxPartySizeDelta >= 0, "partyFairness: maxPartySizeDelta must not be negative")
+		check(c.PartyFairness.SecondsToRelax >= 0, "partyFairness: secondsToRelax must not be negative")
+	}
+
+	check(c.BlockScope == "" || c.BlockScope == BlockScopeMatch || c.BlockScope == BlockScopeTeam,
+		"blockScope must be empty, %q or %q", BlockScopeMatch, BlockScopeTeam)
+	check(c.MinutesToAvoidRematch >= 0, "minutesToAvoidRematch must not be negative")
+
+	if c.StreakCompensation != nil {
+		check(c.StreakCompensation.MatchesToCheck > 0, "streakCompensation: matchesToCheck must be positive")
+		check(c.StreakCompensation.MinStreak >= 0, "streakCompensation: minStreak must not be negative")
+		check(c.StreakCompensation.MaxAdjustment >= 0, "streakCompensation: maxAdjustment must not be negative")
+	}
+
+	for i, rule := range c.PoolRouting {
+		check(rule.Flag != "" && rule.Pool != "", "poolRouting %d: flag and pool must be set", i)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaultMatchmakerConfig(t *testing.T) {
+	_, err := LoadMatchmakerConfig("../matchmaker_config.json")
+	if err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestLoadInvalidMatchmakerConfig(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"teamSize": 1, "teamCount": 2, "unknownField": 1}`, "unknown field"},
+		{`{"teamSize": 0, "teamCount": 2}`, "size must be positive"},
+		{`{"teamSize": 1, "teamCount": 2, "checkReadiness": true}`, "secondsToAcceptMatch"},
+		{`{"teamSize": 5, "teamCount": 2, "roles": {"tank": 1, "carry": 3}}`, "roles"},
+		{`{"teamSize": 1, "teamCount": 2, "admissionRules": [{"type": "unknown"}]}`, "unknown type"},
+		{`{"teamSize": 1, "teamCount": 2, "blockScope": "server"}`, "blockScope"},
+	}
+
+	dir := t.TempDir()
+	for i, test := range tests {
+		path := filepath.Join(dir, "matchmaker_config.json")
+		if err := os.WriteFile(path, []byte(test.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := LoadMatchmakerConfig(path)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("case %d: got %v, want error containing %q", i, err, test.want)
+		}
+	}
+}
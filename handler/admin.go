@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"goplay/matchmaker"
+
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultBucketWidth = 100
+	defaultOldest      = 10
+)
+
+// Admin API for operators to inspect and control queues
+type AdminHandler struct {
+	queues map[string]matchmaker.Matchmaker
+}
+
+func NewAdminHandler(queues map[string]matchmaker.Matchmaker) *AdminHandler {
+	return &AdminHandler{
+		queues: queues,
+	}
+}
+
+// Rejects requests without the bearer token
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+type queueSummary struct {
+	Name  string                `json:"name"`
+	State matchmaker.QueueState `json:"state"`
+	Depth int                   `json:"depth"`
+}
+
+func (h *AdminHandler) ListQueues(c *gin.Context) {
+	queues := make([]queueSummary, 0, len(h.queues))
+	for name, queue := range h.queues {
+		stats := queue.Stats(defaultBucketWidth, 0)
+		queues = append(queues, queueSummary{Name: name, State: stats.State, Depth: stats.Depth})
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
+
+	c.JSON(http.StatusOK, queues)
+}
+
+// Returns queue stats, width of rating buckets and number of the oldest tickets
+// are set by bucket and oldest query params
+func (h *AdminHandler) GetQueue(c *gin.Context) {
+	queue, ok := h.queue(c)
+	if !ok {
+		return
+	}
+
+	bucketWidth, err := strconv.Atoi(c.DefaultQuery("bucket", strconv.Itoa(defaultBucketWidth)))
+	if err != nil || bucketWidth <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be a positive number"})
+		return
+	}
+	oldest, err := strconv.Atoi(c.DefaultQuery("oldest", strconv.Itoa(defaultOldest)))
+	if err != nil || oldest < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "oldest must not be negative"})
+		return
+	}
+
+	c.JSON(http.StatusOK, queue.Stats(bucketWidth, oldest))
+}
+
+func (h *AdminHandler) CancelTicket(c *gin.Context) {
+	queue, ok := h.queue(c)
+	if !ok {
+		return
+	}
+
+	if !queue.RemoveGroup(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ticket is not in search"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *AdminHandler) Pause(c *gin.Context) {
+	h.control(c, matchmaker.Matchmaker.Pause)
+}
+
+func (h *AdminHandler) Resume(c *gin.Context) {
+	h.control(c, matchmaker.Matchmaker.Resume)
+}
+
+func (h *AdminHandler) Drain(c *gin.Context) {
+	h.control(c, matchmaker.Matchmaker.Drain)
+}
+
+func (h *AdminHandler) RunPass(c *gin.Context) {
+	h.control(c, matchmaker.Matchmaker.RunPass)
+}
+
+// Applies action to the queue and responds with its new state
+func (h *AdminHandler) control(c *gin.Context, action func(queue matchmaker.Matchmaker)) {
+	queue, ok := h.queue(c)
+	if !ok {
+		return
+	}
+
+	action(queue)
+	stats := queue.Stats(defaultBucketWidth, 0)
+	c.JSON(http.StatusOK, queueSummary{Name: c.Param("queue"), State: stats.State, Depth: stats.Depth})
+}
+
+func (h *AdminHandler) queue(c *gin.Context) (matchmaker.Matchmaker, bool) {
+	queue, ok := h.queues[c.Param("queue")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown queue " + c.Param("queue")})
+	}
+
+	return queue, ok
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/auth"
+
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Puts caller of the request into its context, requests without valid token are rejected.
+// All requests are allowed if authenticator is nil.
+func Authenticate(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticator == nil {
+			c.Next()
+			return
+		}
+
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		caller, err := authenticator.Authenticate(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			writeError(c, newAPIError(codeUnauthenticated, "%s", err))
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), caller))
+		c.Next()
+	}
+}
+
+// Allows the route only to the given services or to any service if none are given
+func RequireService(names ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authorizeService(c.Request.Context(), names...); err != nil {
+			writeError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func authorizeService(ctx context.Context, names ...string) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !caller.IsService() {
+		return newAPIError(codePermissionDenied, "only services are allowed")
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if caller.Service == name {
+			return nil
+		}
+	}
+
+	return newAPIError(codePermissionDenied, "service %s is not allowed", caller.Service)
+}
+
+// Player may act only for itself, services may act for any player
+func authorizePlayer(ctx context.Context, playerID int) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.IsService() || caller.PlayerID == playerID {
+		return nil
+	}
+
+	return newAPIError(codePermissionDenied, "player %d may not act for player %d", caller.PlayerID, playerID)
+}
+
+// Player may see and cancel only tickets it's in
+func authorizeTicket(ctx context.Context, ticket *api.Ticket) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.IsService() {
+		return nil
+	}
+	for _, id := range ticket.PlayerIds {
+		if int(id) == caller.PlayerID {
+			return nil
+		}
+	}
+
+	return newAPIError(codePermissionDenied, "player %d is not in ticket %s", caller.PlayerID, ticket.Id)
+}
+
+// Authenticates gRPC calls by authorization metadata like REST requests
+func grpcAuthenticate(ctx context.Context, authenticator auth.Authenticator) (context.Context, error) {
+	var token string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	caller, err := authenticator.Authenticate(token)
+	if err != nil {
+		return nil, grpcError(newAPIError(codeUnauthenticated, "%s", err))
+	}
+
+	return auth.NewContext(ctx, caller), nil
+}
+
+// Service requirements of gRPC methods, they match REST routes
+func grpcAuthorize(ctx context.Context, method, lobbyService string) error {
+	switch method {
+	case api.Matchmaker_CreateTicket_FullMethodName:
+		return authorizeService(ctx, lobbyService)
+	case api.Matchmaker_ReportMatchResult_FullMethodName:
+		return authorizeService(ctx)
+	}
+
+	return nil
+}
+
+func grpcAuthenticateUnary(authenticator auth.Authenticator, lobbyService string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := grpcAuthenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		if err := grpcAuthorize(ctx, info.FullMethod, lobbyService); err != nil {
+			return nil, grpcError(err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func grpcAuthenticateStream(authenticator auth.Authenticator, lobbyService string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcAuthenticate(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+		if err := grpcAuthorize(ctx, info.FullMethod, lobbyService); err != nil {
+			return grpcError(err)
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Stream with context which has the caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"goplay/auth"
+	"goplay/cluster"
+	"goplay/config"
+
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// Callers by token
+type testAuthenticator map[string]*auth.Caller
+
+func (a testAuthenticator) Authenticate(token string) (*auth.Caller, error) {
+	caller, ok := a[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return caller, nil
+}
+
+func TestAuthorization(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, testAuthenticator{
+		"lobby": {Service: "lobby"},
+		"game":  {Service: "game"},
+		"p1":    {PlayerID: 1},
+		"p2":    {PlayerID: 2},
+	})
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL
+
+	ticket := map[string]any{"id": "1", "queue": "a", "playerIds": []int{1}}
+	result := map[string]any{"teams": []map[string]any{{"playerIds": []int{1}}, {"playerIds": []int{2}}}}
+	for _, tc := range []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{"no token", "", http.MethodPost, "/v1/tickets", ticket, http.StatusUnauthorized},
+		{"invalid token", "p3", http.MethodGet, "/v1/tickets/1", nil, http.StatusUnauthorized},
+		{"player creates ticket", "p1", http.MethodPost, "/v1/tickets", ticket, http.StatusForbidden},
+		{"other service creates ticket", "game", http.MethodPost, "/v1/tickets", ticket, http.StatusForbidden},
+		{"lobby creates ticket", "lobby", http.MethodPost, "/v1/tickets", ticket, http.StatusCreated},
+		{"other player reads ticket", "p2", http.MethodGet, "/v1/tickets/1", nil, http.StatusForbidden},
+		{"player reads own ticket", "p1", http.MethodGet, "/v1/tickets/1", nil, http.StatusOK},
+		{"player accepts for other player", "p2", http.MethodPost, "/v1/players/1/accept", nil, http.StatusForbidden},
+		{"player accepts", "p1", http.MethodPost, "/v1/players/1/accept", nil, http.StatusNoContent},
+		{"legacy ready for other player", "p2", http.MethodPost, "/players/ready", SetPlayerReadyReq{PlayerId: 1}, http.StatusForbidden},
+		{"legacy ready", "p1", http.MethodPost, "/players/ready", SetPlayerReadyReq{PlayerId: 1}, http.StatusOK},
+		{"player cancels legacy group", "p1", http.MethodDelete, "/teams", RemoveGroupReq{ID: "1"}, http.StatusForbidden},
+		{"player reports result", "p1", http.MethodPost, "/v1/matches/m1/result", result, http.StatusForbidden},
+		{"other player cancels ticket", "p2", http.MethodDelete, "/v1/tickets/1", nil, http.StatusForbidden},
+		{"player cancels own ticket", "p1", http.MethodDelete, "/v1/tickets/1", nil, http.StatusOK},
+	} {
+		status, body := sendRequestAs(t, tc.token, tc.method, url+tc.path, tc.body)
+		if status != tc.status {
+			t.Errorf("%s: got status %d, body %s, want %d", tc.name, status, body, tc.status)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil, func(cfg *config.Config) {
+		cfg.Server.AdminToken = "admin-token"
+	})
+	url := instance.server.URL + "/admin/queues"
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer admin", http.StatusUnauthorized},
+		{"token without scheme", "admin-token", http.StatusUnauthorized},
+		{"valid token", "Bearer admin-token", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%s: got status %d, want %d", tc.name, res.StatusCode, tc.status)
+		}
+	}
+
+	disabled := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil)
+	if status, _ := sendRequestAs(t, "admin-token", http.MethodGet, disabled.server.URL+"/admin/queues", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d without admin token in config, want %d", status, http.StatusNotFound)
+	}
+}
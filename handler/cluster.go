@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"goplay/cluster"
+
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	// Marks requests sent by another instance, they are handled locally and never forwarded again.
+	// Its value is signed by the instance, unsigned marks of clients are dropped by VerifyForwarded.
+	forwardedHeader = "X-Goplay-Forwarded"
+	// Signed requests older than this are not trusted, so captured ones can't be replayed later
+	forwardedMaxAge = time.Minute
+)
+
+// Drops forwarded mark of requests which are not signed by an instance of the cluster,
+// so clients can't skip forwarding and rate limits with it
+func VerifyForwarded(node *cluster.Node) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(forwardedHeader)
+		if value == "" {
+			c.Next()
+			return
+		}
+		c.Request.Header.Del(forwardedHeader)
+
+		ts, signature, _ := strings.Cut(value, ":")
+		at, err := strconv.ParseInt(ts, 10, 64)
+		if node == nil || err != nil || time.Since(time.Unix(at, 0)).Abs() > forwardedMaxAge {
+			c.Next()
+			return
+		}
+		body, err := cachedBody(c)
+		if err == nil && node.Verify(forwardedMessage(c.Request.Method, c.Request.URL.RequestURI(), ts, body), signature) {
+			c.Request.Header.Set(forwardedHeader, value)
+		}
+
+		c.Next()
+	}
+}
+
+// Marks the request as sent by this instance
+func signForwarded(node *cluster.Node, req *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(forwardedHeader, ts+":"+node.Sign(forwardedMessage(req.Method, req.URL.RequestURI(), ts, body)))
+}
+
+func forwardedMessage(method, uri, ts string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(method + " " + uri + "\n" + ts + "\n" + hex.EncodeToString(sum[:]))
+}
+
+// Reads the body once, later it's read from the context by handlers and forwarding
+func cachedBody(c *gin.Context) ([]byte, error) {
+	if cached, ok := c.Get(gin.BodyBytesKey); ok {
+		return cached.([]byte), nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(gin.BodyBytesKey, body)
+	// Request validation reads the body from the request
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// Returns address of the instance which owns the queue, empty if the request is handled by this one
+func (h *HttpHandler) queueOwner(c *gin.Context, queue string) (string, error) {
+	if h.node == nil || c.GetHeader(forwardedHeader) != "" {
+		return "", nil
+	}
+
+	owner, err := h.node.Owner(queue)
+	if err != nil || owner == h.node.Addr() {
+		return "", err
+	}
+
+	return owner, nil
+}
+
+// Proxies the request to the instance, its response is written as is
+func forward(c *gin.Context, node *cluster.Node, addr string) {
+	target, err := url.Parse(addr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = otelhttp.NewTransport(http.DefaultTransport)
+	var body []byte
+	if cached, ok := c.Get(gin.BodyBytesKey); ok {
+		body = cached.([]byte)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	signForwarded(node, c.Request, body)
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// Sends the request to other instances, when it's not known which of them has the ticket or player
+func broadcast(c *gin.Context, node *cluster.Node) {
+	if node == nil || c.GetHeader(forwardedHeader) != "" {
+		return
+	}
+
+	for _, peer := range node.Peers() {
+		res, err := sendToPeer(c, node, peer)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "failed to forward request", "instance", peer, "error", err)
+			continue
+		}
+		res.Body.Close()
+	}
+}
+
+// Sends the request to other instances until one of them has the resource and writes its response.
+// Returns false if none of them has it.
+func findOnPeers(c *gin.Context, node *cluster.Node) bool {
+	if node == nil || c.GetHeader(forwardedHeader) != "" {
+		return false
+	}
+
+	for _, peer := range node.Peers() {
+		res, err := sendToPeer(c, node, peer)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "failed to forward request", "instance", peer, "error", err)
+			continue
+		}
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			continue
+		}
+
+		c.DataFromReader(res.StatusCode, res.ContentLength, res.Header.Get("Content-Type"), res.Body, nil)
+		res.Body.Close()
+		return true
+	}
+
+	return false
+}
+
+func sendToPeer(c *gin.Context, node *cluster.Node, peer string) (*http.Response, error) {
+	var body []byte
+	if cached, ok := c.Get(gin.BodyBytesKey); ok {
+		body = cached.([]byte)
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, peer+c.Request.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.Request.Header.Clone()
+	signForwarded(node, req, body)
+
+	client := http.Client{Timeout: 5 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	return client.Do(req)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"goplay/auth"
+	"goplay/cluster"
+	"goplay/config"
+	"goplay/matchmaker"
+	"goplay/repository/repositorytest"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testInstance struct {
+	server *httptest.Server
+	node   *cluster.Node
+	queues map[string]matchmaker.Matchmaker
+}
+
+// Instance with 1 vs 1 queues a and b, its matches are sent to the server named by instance address
+func newTestInstance(t *testing.T, coordinator cluster.Coordinator, shards []string, authenticator auth.Authenticator,
+	configure ...func(cfg *config.Config)) *testInstance {
+	gin.SetMode(gin.TestMode)
+	var router http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Server:     config.ServerConfig{DBRequestTimeout: config.Duration{Duration: time.Second}, LegacyRoutes: true},
+		Matchmaker: config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100},
+		Auth:       config.AuthConfig{LobbyService: "lobby"},
+	}
+	for _, f := range configure {
+		f(cfg)
+	}
+	repo := &repositorytest.Repository{DefaultRating: 100}
+	tickets := NewTickets()
+	queues := make(map[string]matchmaker.Matchmaker)
+	for _, name := range []string{"a", "b"} {
+		queues[name] = matchmaker.NewMatchmaker(repo, cfg, func(ctx context.Context, match *matchmaker.Match, sendTo string) (string, error) {
+			return server.URL, nil
+		}, matchmaker.WithTicketListener(tickets.Queue(name)))
+		go queues[name].Run()
+	}
+
+	node := cluster.NewNode(server.URL, coordinator, []string{"a", "b"}, time.Minute, cluster.WithShards(shards),
+		cluster.WithSecret("test-cluster-secret"))
+	cooldowns := NewCooldowns(cfg.RateLimit.RequeueCooldown.Duration)
+	v1, err := NewV1Handler(NewTicketService(queues, tickets, repo, node, cooldowns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router = NewRouter(cfg, NewHttpHandler(queues, node, cooldowns), v1, NewAdminHandler(queues), http.NotFoundHandler(),
+		authenticator, NewRateLimits(cfg.RateLimit))
+
+	return &testInstance{server: server, node: node, queues: queues}
+}
+
+func sendRequest(t *testing.T, method, url string, body any) (int, string) {
+	return sendRequestAs(t, "", method, url, body)
+}
+
+// Sends request with bearer token unless it's empty
+func sendRequestAs(t *testing.T, token, method, url string, body any) (int, string) {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, ""
+	}
+	defer res.Body.Close()
+	out, _ := io.ReadAll(res.Body)
+
+	return res.StatusCode, string(out)
+}
+
+func TestClusterForwarding(t *testing.T) {
+	coordinator := cluster.NewLocalCoordinator()
+	first := newTestInstance(t, coordinator, []string{"a"}, nil)
+	second := newTestInstance(t, coordinator, nil, nil)
+	first.node.Refresh(context.Background())
+	second.node.Refresh(context.Background())
+	first.node.Refresh(context.Background())
+
+	if !first.node.Owns("a") || !second.node.Owns("b") {
+		t.Fatal("queues are not split between instances")
+	}
+
+	// Both tickets go to the first instance, but queue b is matched by the second one
+	results := make(chan string, 2)
+	for _, id := range []int{1, 2} {
+		go func(id int) {
+			_, body := sendRequest(t, http.MethodPost, first.server.URL+"/teams",
+				AddGroupReq{ID: fmt.Sprint(id), PlayerIDs: []int{id}, Queue: "b"})
+			results <- body
+		}(id)
+	}
+	want, _ := json.Marshal(second.server.URL)
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-results:
+			if body != string(want) {
+				t.Errorf("got response %s, want match on server %s", body, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("tickets are not matched")
+		}
+	}
+
+	// Ticket is cancelled on the instance which doesn't have it
+	cancelled := make(chan int, 1)
+	go func() {
+		status, _ := sendRequest(t, http.MethodPost, first.server.URL+"/teams", AddGroupReq{ID: "3", PlayerIDs: []int{3}, Queue: "b"})
+		cancelled <- status
+	}()
+	for second.queues["b"].Stats(100, 0).Depth == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first.queues["b"].Stats(100, 0).Depth != 0 {
+		t.Error("ticket is queued by instance which doesn't own the queue")
+	}
+
+	sendRequest(t, http.MethodDelete, first.server.URL+"/teams", RemoveGroupReq{ID: "3"})
+	select {
+	case status := <-cancelled:
+		if status != http.StatusOK {
+			t.Errorf("got status %d, want %d", status, http.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ticket is not cancelled")
+	}
+}
+
+func TestLegacyGroupWithoutPlayers(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil)
+	instance.node.Refresh(context.Background())
+
+	status, _ := sendRequest(t, http.MethodPost, instance.server.URL+"/teams", AddGroupReq{ID: "1", Queue: "a"})
+	if status != http.StatusBadRequest {
+		t.Errorf("got status %d of group without players, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/auth"
+	"goplay/repository"
+
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serves gRPC API with the same matchmakers as HttpHandler
+type GrpcHandler struct {
+	api.UnimplementedMatchmakerServer
+	service *TicketService
+}
+
+func NewGrpcHandler(service *TicketService) *GrpcHandler {
+	return &GrpcHandler{
+		service: service,
+	}
+}
+
+// Trace context of callers is extracted from request metadata like from HTTP headers.
+// Calls are authenticated unless authenticator is nil.
+func NewGrpcServer(handler *GrpcHandler, authenticator auth.Authenticator, lobbyService string, limits *RateLimits) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{grpcRecoverUnary}
+	stream := []grpc.StreamServerInterceptor{grpcRecoverStream}
+	if authenticator != nil {
+		unary = append(unary, grpcAuthenticateUnary(authenticator, lobbyService))
+		stream = append(stream, grpcAuthenticateStream(authenticator, lobbyService))
+	}
+	unary = append(unary, grpcRateLimit(limits, handler.service.tickets))
+
+	server := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	api.RegisterMatchmakerServer(server, handler)
+
+	return server
+}
+
+// Panic in a call is returned as internal error like in HTTP API, so it doesn't stop the server
+func grpcRecoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ctx, info.FullMethod, r)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func grpcRecoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ss.Context(), info.FullMethod, r)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func recovered(ctx context.Context, method string, r any) error {
+	slog.ErrorContext(ctx, "grpc call panicked", "method", method, "panic", r, "stack", string(debug.Stack()))
+	return status.Error(codes.Internal, "internal error")
+}
+
+var grpcCodes = map[string]codes.Code{
+	codeInvalidRequest:       codes.InvalidArgument,
+	codeUnauthenticated:      codes.Unauthenticated,
+	codePermissionDenied:     codes.PermissionDenied,
+	codeUnknownQueue:         codes.InvalidArgument,
+	codeNotFound:             codes.NotFound,
+	codeAlreadyExists:        codes.AlreadyExists,
+	codeAdmissionRejected:    codes.FailedPrecondition,
+	codeTicketInMatch:        codes.FailedPrecondition,
+	codeQueueUnavailable:     codes.Unavailable,
+	codeRateLimited:          codes.ResourceExhausted,
+	codeRequeueCooldown:      codes.ResourceExhausted,
+	codeIdempotencyKeyReused: codes.InvalidArgument,
+	codeInternal:             codes.Internal,
+}
+
+func grpcError(err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return status.Error(grpcCodes[apiErr.code], apiErr.message)
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+type grpcResult struct {
+	ticket *api.Ticket
+	err    error
+}
+
+// Calls with idempotency-key metadata are done once, retries get the result of the first call
+func (h *GrpcHandler) CreateTicket(ctx context.Context, req *api.CreateTicketRequest) (*api.Ticket, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "idempotency-key")
+	if len(values) == 0 || values[0] == "" {
+		return h.createTicket(ctx, req)
+	}
+
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	fingerprint := sha256.Sum256(append([]byte(api.Matchmaker_CreateTicket_FullMethodName+"\n"), body...))
+
+	result, _, err := h.service.idempotency.do(ctx, idempotencyKey(ctx, values[0]), fingerprint, func() any {
+		ticket, err := h.createTicket(ctx, req)
+		return &grpcResult{ticket, err}
+	}, func(result any) bool {
+		switch status.Code(result.(*grpcResult).err) {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Canceled, codes.DeadlineExceeded:
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return result.(*grpcResult).ticket, result.(*grpcResult).err
+}
+
+func (h *GrpcHandler) createTicket(ctx context.Context, req *api.CreateTicketRequest) (*api.Ticket, error) {
+	owner, err := h.service.owner(req.Queue)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	// Tickets are kept in memory of the instance, so they are not forwarded
+	if owner != "" {
+		return nil, status.Errorf(codes.Unavailable, "queue is owned by instance %s", owner)
+	}
+
+	ticketReq := ticketRequest{
+		ID:        req.Id,
+		Queue:     req.Queue,
+		PlayerIDs: make([]int, len(req.PlayerIds)),
+		Roles:     make(map[int][]string),
+	}
+	for i, id := range req.PlayerIds {
+		ticketReq.PlayerIDs[i] = int(id)
+	}
+	for id, playerRoles := range req.Roles {
+		ticketReq.Roles[int(id)] = playerRoles.Roles
+	}
+
+	ticket, err := h.service.create(ctx, ticketReq)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return ticket, nil
+}
+
+func (h *GrpcHandler) CancelTicket(ctx context.Context, req *api.CancelTicketRequest) (*api.Ticket, error) {
+	ticket, err := h.service.cancel(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return ticket, nil
+}
+
+func (h *GrpcHandler) GetTicket(ctx context.Context, req *api.GetTicketRequest) (*api.Ticket, error) {
+	ticket, err := h.service.get(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return ticket, nil
+}
+
+func (h *GrpcHandler) WatchTicket(req *api.WatchTicketRequest, stream api.Matchmaker_WatchTicketServer) error {
+	for {
+		ticket, changed, ok := h.service.tickets.get(req.Id)
+		if !ok {
+			return status.Errorf(codes.NotFound, "ticket %s is not found", req.Id)
+		}
+		if err := authorizeTicket(stream.Context(), ticket); err != nil {
+			return grpcError(err)
+		}
+		if err := stream.Send(ticket); err != nil {
+			return err
+		}
+		if finished(ticket) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (h *GrpcHandler) AcceptMatch(ctx context.Context, req *api.AcceptMatchRequest) (*api.AcceptMatchResponse, error) {
+	if err := h.service.accept(ctx, int(req.PlayerId)); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &api.AcceptMatchResponse{}, nil
+}
+
+func (h *GrpcHandler) DeclineMatch(ctx context.Context, req *api.DeclineMatchRequest) (*api.DeclineMatchResponse, error) {
+	if err := h.service.decline(ctx, int(req.PlayerId)); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &api.DeclineMatchResponse{}, nil
+}
+
+func (h *GrpcHandler) ReportMatchResult(ctx context.Context, req *api.ReportMatchResultRequest) (*api.ReportMatchResultResponse, error) {
+	result := repository.MatchResult{
+		MatchID: req.MatchId,
+		Teams:   make([][]int, len(req.Teams)),
+		Won:     make([]bool, len(req.Teams)),
+	}
+	for i, team := range req.Teams {
+		for _, id := range team.PlayerIds {
+			result.Teams[i] = append(result.Teams[i], int(id))
+		}
+		result.Won[i] = team.Won
+	}
+
+	err := h.service.reportResult(ctx, result)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &api.ReportMatchResultResponse{}, nil
+}
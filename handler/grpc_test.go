@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/auth"
+	"goplay/config"
+	"goplay/matchmaker"
+	"goplay/repository"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGrpcClient(t *testing.T, repo repository.Repository, authenticator auth.Authenticator) api.MatchmakerClient {
+	tickets := NewTickets()
+	cfg := &config.Config{
+		Server: config.ServerConfig{DBRequestTimeout: config.Duration{Duration: time.Second}},
+		Matchmaker: config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100,
+			CheckReadiness: true, SecondsToAcceptMatch: 5},
+	}
+	queue := matchmaker.NewMatchmaker(repo, cfg, func(ctx context.Context, match *matchmaker.Match, sendTo string) (string, error) {
+		return "server-" + match.ID, nil
+	}, matchmaker.WithTicketListener(tickets.Queue(config.DefaultQueue)))
+	go queue.Run()
+	queues := map[string]matchmaker.Matchmaker{config.DefaultQueue: queue}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGrpcServer(NewGrpcHandler(NewTicketService(queues, tickets, repo, nil, nil)), authenticator, "lobby",
+		NewRateLimits(config.RateLimitConfig{}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return api.NewMatchmakerClient(conn)
+}
+
+// Receives updates of the ticket until it's in the given state
+func waitForState(t *testing.T, stream api.Matchmaker_WatchTicketClient, state api.TicketState) *api.Ticket {
+	for {
+		ticket, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("ticket didn't get to state %s: %v", state, err)
+		}
+		if ticket.State == state {
+			return ticket
+		}
+	}
+}
+
+func TestGrpcTicketLifecycle(t *testing.T) {
+	repo := &repositorytest.Repository{DefaultRating: 100, Results: make(chan repository.MatchResult, 1)}
+	client := newTestGrpcClient(t, repo, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, id := range []int64{1, 2} {
+		ticket, err := client.CreateTicket(ctx, &api.CreateTicketRequest{Id: string(rune('0' + id)), PlayerIds: []int64{id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ticket.State != api.TicketState_TICKET_STATE_QUEUED && ticket.State != api.TicketState_TICKET_STATE_MATCHING {
+			t.Errorf("got state %s of new ticket, want it in search", ticket.State)
+		}
+	}
+	_, err := client.CreateTicket(ctx, &api.CreateTicketRequest{Id: "1", PlayerIds: []int64{3}})
+	if err == nil {
+		t.Error("ticket with ID of unfinished ticket is created")
+	}
+
+	stream, err := client.WatchTicket(ctx, &api.WatchTicketRequest{Id: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matching := waitForState(t, stream, api.TicketState_TICKET_STATE_MATCHING)
+	client.AcceptMatch(ctx, &api.AcceptMatchRequest{PlayerId: 1})
+	client.AcceptMatch(ctx, &api.AcceptMatchRequest{PlayerId: 2})
+	found := waitForState(t, stream, api.TicketState_TICKET_STATE_FOUND)
+	if found.ServerId != "server-"+matching.MatchId {
+		t.Errorf("got server %q, want server of match %s", found.ServerId, matching.MatchId)
+	}
+
+	ticket, err := client.GetTicket(ctx, &api.GetTicketRequest{Id: "2"})
+	if err != nil || ticket.State != api.TicketState_TICKET_STATE_FOUND {
+		t.Errorf("got ticket %v, error %v, want the other ticket of the match found", ticket, err)
+	}
+
+	_, err = client.ReportMatchResult(ctx, &api.ReportMatchResultRequest{MatchId: matching.MatchId, Teams: []*api.TeamResult{
+		{PlayerIds: []int64{1}, Won: true},
+		{PlayerIds: []int64{2}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := <-repo.Results
+	if result.MatchID != matching.MatchId || len(result.Teams) != 2 || !result.Won[0] || result.Won[1] {
+		t.Errorf("got result %v, want first team won", result)
+	}
+
+	_, err = client.ReportMatchResult(ctx, &api.ReportMatchResultRequest{MatchId: matching.MatchId, Teams: []*api.TeamResult{
+		{PlayerIds: []int64{1}, Won: true},
+		{PlayerIds: []int64{2}},
+	}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v of repeated report, want %s", err, codes.AlreadyExists)
+	}
+}
+
+func TestGrpcDeclineMatch(t *testing.T) {
+	client := newTestGrpcClient(t, &repositorytest.Repository{DefaultRating: 100}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, id := range []int64{3, 4} {
+		_, err := client.CreateTicket(ctx, &api.CreateTicketRequest{Id: string(rune('0' + id)), PlayerIds: []int64{id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	declined, _ := client.WatchTicket(ctx, &api.WatchTicketRequest{Id: "3"})
+	other, _ := client.WatchTicket(ctx, &api.WatchTicketRequest{Id: "4"})
+
+	waitForState(t, declined, api.TicketState_TICKET_STATE_MATCHING)
+	client.DeclineMatch(ctx, &api.DeclineMatchRequest{PlayerId: 3})
+	ticket := waitForState(t, declined, api.TicketState_TICKET_STATE_CANCELLED)
+	if ticket.CancelReason != string(matchmaker.CancelledNotReady) {
+		t.Errorf("got reason %q, want %q", ticket.CancelReason, matchmaker.CancelledNotReady)
+	}
+
+	// The other group is returned to search and may leave it
+	waitForState(t, other, api.TicketState_TICKET_STATE_MATCHING)
+	waitForState(t, other, api.TicketState_TICKET_STATE_QUEUED)
+	ticket, err := client.CancelTicket(ctx, &api.CancelTicketRequest{Id: "4"})
+	if err != nil || ticket.State != api.TicketState_TICKET_STATE_CANCELLED || ticket.CancelReason != string(matchmaker.CancelledByRequest) {
+		t.Errorf("got ticket %v, error %v, want it cancelled by request", ticket, err)
+	}
+}
+
+func TestGrpcAuthorization(t *testing.T) {
+	client := newTestGrpcClient(t, &repositorytest.Repository{DefaultRating: 100}, testAuthenticator{
+		"lobby": {Service: "lobby"},
+		"p1":    {PlayerID: 1},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	req := &api.CreateTicketRequest{Id: "1", PlayerIds: []int64{1}}
+	_, err := client.CreateTicket(ctx, req)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v without token, want %s", err, codes.Unauthenticated)
+	}
+	_, err = client.CreateTicket(as("p1"), req)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v of player, want %s", err, codes.PermissionDenied)
+	}
+	_, err = client.CreateTicket(as("lobby"), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.WatchTicket(as("p1"), &api.WatchTicketRequest{Id: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Errorf("got error %v of watching own ticket", err)
+	}
+	_, err = client.AcceptMatch(as("p1"), &api.AcceptMatchRequest{PlayerId: 2})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v of accepting for other player, want %s", err, codes.PermissionDenied)
+	}
+}
+
+func TestGrpcIdempotentCreate(t *testing.T) {
+	client := newTestGrpcClient(t, &repositorytest.Repository{DefaultRating: 100}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "idempotency-key", "key-1")
+
+	first, err := client.CreateTicket(ctx, &api.CreateTicketRequest{Id: "1", PlayerIds: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := client.CreateTicket(ctx, &api.CreateTicketRequest{Id: "1", PlayerIds: []int64{1}})
+	if err != nil || retry.Id != first.Id {
+		t.Errorf("got ticket %v, error %v of retry, want ticket %s", retry, err, first.Id)
+	}
+
+	_, err = client.CreateTicket(ctx, &api.CreateTicketRequest{Id: "2", PlayerIds: []int64{2}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v of reused key, want %s", err, codes.InvalidArgument)
+	}
+}
+
+// Repository which panics on player lookup
+type panickingRepository struct {
+	repositorytest.Repository
+}
+
+func (r *panickingRepository) GetUsersById(ctx context.Context, ids []int) ([]repository.PlayerInfo, error) {
+	panic("lookup failed")
+}
+
+func TestGrpcCreateTicketErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := newTestGrpcClient(t, &repositorytest.Repository{Players: map[int]repository.PlayerInfo{1: {ID: 1, Rating: 100}}}, nil)
+	for _, tc := range []struct {
+		playerIDs []int64
+		code      codes.Code
+	}{
+		{[]int64{1, 2}, codes.NotFound},
+		{[]int64{1, 1}, codes.InvalidArgument},
+		{nil, codes.InvalidArgument},
+	} {
+		_, err := client.CreateTicket(ctx, &api.CreateTicketRequest{PlayerIds: tc.playerIDs})
+		if status.Code(err) != tc.code {
+			t.Errorf("players %v: got error %v, want %s", tc.playerIDs, err, tc.code)
+		}
+	}
+
+	client = newTestGrpcClient(t, &panickingRepository{}, nil)
+	_, err := client.CreateTicket(ctx, &api.CreateTicketRequest{PlayerIds: []int64{1}})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("got error %v of panicked call, want %s", err, codes.Internal)
+	}
+	if _, err := client.GetTicket(ctx, &api.GetTicketRequest{Id: "1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v after panic, want server still serving", err)
+	}
+}
@@ -1,53 +1,185 @@
 package handler
 
 import (
+	"goplay/cluster"
+	"goplay/config"
 	"goplay/matchmaker"
 
+	"errors"
+	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type HttpHandler struct {
-	matchmaker matchmaker.Matchmaker
+	// Matchmakers by queue name
+	queues map[string]matchmaker.Matchmaker
+	// Instance in the cluster, nil if the matchmaker runs as a single instance
+	node      *cluster.Node
+	cooldowns *Cooldowns
+
+	mu sync.Mutex
+	// Groups waiting for response, so cancelled groups get requeue cooldown and retries get the same response
+	groups map[string]*legacyGroup
 }
 
-func NewHttpHandler(matchmaker matchmaker.Matchmaker) *HttpHandler {
+// Group of the request to legacy API which waits for the result of search
+type legacyGroup struct {
+	playerIDs []int
+	// Closed when the first request gets the response, its retries get the same one
+	done   chan struct{}
+	status int
+	body   any
+}
+
+func NewHttpHandler(queues map[string]matchmaker.Matchmaker, node *cluster.Node, cooldowns *Cooldowns) *HttpHandler {
 	return &HttpHandler{
-		matchmaker: matchmaker,
+		queues:    queues,
+		node:      node,
+		cooldowns: cooldowns,
+		groups:    make(map[string]*legacyGroup),
 	}
 }
 
 type AddGroupReq struct {
 	ID        string
 	PlayerIDs []int
+	// Preferred roles of players by player ID, most wanted first
+	Roles map[int][]string
+	// Name of the queue, default queue is used if empty
+	Queue string
 }
 
 func (h *HttpHandler) AddGroup(c *gin.Context) {
 	var req AddGroupReq
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(req.PlayerIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group has no players"})
+		return
+	}
 
-	found := make(chan string)
-	cancelled := make(chan bool)
-	err := h.matchmaker.AddGroup(c.Request.Context(), req.ID, req.PlayerIDs, found, cancelled)
+	if req.Queue == "" {
+		req.Queue = config.DefaultQueue
+	}
+	queue, ok := h.queues[req.Queue]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown queue " + req.Queue})
+		return
+	}
+
+	owner, err := h.queueOwner(c, req.Queue)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if owner != "" {
+		forward(c, h.node, owner)
 		return
 	}
 
-	for {
-		select {
-		case serverId := <-found:
-			c.JSON(http.StatusOK, serverId)
-			return
-		case <-cancelled:
-			c.Status(http.StatusOK)
+	if remaining := h.cooldowns.Remaining(req.PlayerIDs); remaining > 0 {
+		writeRetryAfter(c, remaining)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": cooldownError(remaining).Error()})
+		return
+	}
+
+	// Group may be cancelled as soon as it is added.
+	// Group IDs are unique across queues, so the same group can't wait in another queue.
+	// Retry of the request without idempotency key, e.g. after network error, waits for the response of the first one.
+	h.mu.Lock()
+	group, exists := h.groups[req.ID]
+	if !exists {
+		group = &legacyGroup{playerIDs: req.PlayerIDs, done: make(chan struct{}),
+			status: http.StatusInternalServerError, body: gin.H{"error": "search failed"}}
+		h.groups[req.ID] = group
+	}
+	h.mu.Unlock()
+	if exists {
+		if !slices.Equal(group.playerIDs, req.PlayerIDs) {
+			c.JSON(http.StatusConflict, gin.H{"error": matchmaker.ErrGroupExists.Error()})
 			return
 		}
+		select {
+		case <-group.done:
+			writeGroupResponse(c, group.status, group.body)
+		case <-c.Request.Context().Done():
+		}
+		return
+	}
+	defer func() {
+		h.mu.Lock()
+		delete(h.groups, req.ID)
+		h.mu.Unlock()
+		close(group.done)
+	}()
+
+	group.status, group.body = h.search(c, queue, req)
+	writeGroupResponse(c, group.status, group.body)
+}
+
+// Adds group to the queue and waits until it leaves search, returns status and body of the response
+func (h *HttpHandler) search(c *gin.Context, queue matchmaker.Matchmaker, req AddGroupReq) (int, any) {
+	// Buffered, so matchmaker doesn't block if the request is already gone
+	found := make(chan string, 1)
+	cancelled := make(chan matchmaker.CancelReason, 1)
+	err := queue.AddGroup(c.Request.Context(), req.ID, req.PlayerIDs, req.Roles, found, cancelled)
+	var admissionErr *matchmaker.AdmissionError
+	if errors.As(err, &admissionErr) {
+		return http.StatusForbidden, gin.H{"error": err.Error(), "rule": admissionErr.Rule}
+	}
+	if errors.Is(err, matchmaker.ErrQueueDraining) || errors.Is(err, matchmaker.ErrQueueNotOwned) {
+		return http.StatusServiceUnavailable, gin.H{"error": err.Error()}
+	}
+	if errors.Is(err, matchmaker.ErrGroupExists) {
+		return http.StatusConflict, gin.H{"error": err.Error()}
+	}
+	if errors.Is(err, matchmaker.ErrInvalidGroup) {
+		return http.StatusBadRequest, gin.H{"error": err.Error()}
+	}
+	if errors.Is(err, matchmaker.ErrPlayersNotFound) {
+		return http.StatusNotFound, gin.H{"error": err.Error()}
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to add group", "group_id", req.ID, "player_ids", req.PlayerIDs,
+			"queue", req.Queue, "error", err)
+		return http.StatusInternalServerError, gin.H{"error": err.Error()}
+	}
+
+	select {
+	case serverId := <-found:
+		return http.StatusOK, serverId
+	case reason := <-cancelled:
+		switch reason {
+		case matchmaker.CancelledByRequest:
+			return http.StatusOK, nil
+		case matchmaker.CancelledNotReady:
+			return http.StatusConflict, gin.H{"error": "match is not accepted by players of the group"}
+		case matchmaker.CancelledOwnerChanged:
+			// Request queued again is forwarded to the new owner
+			return http.StatusServiceUnavailable, gin.H{"error": "queue moved to another instance", "kept": false}
+		default:
+			// Lobby may queue the group again, kept group is also restored after restart
+			return http.StatusServiceUnavailable, gin.H{"error": "search is cancelled for maintenance",
+				"kept": reason == matchmaker.SuspendedForRestart}
+		}
+	}
+}
+
+// Response without body has only status
+func writeGroupResponse(c *gin.Context, status int, body any) {
+	if body == nil {
+		c.Status(status)
+		return
 	}
+
+	c.JSON(status, body)
 }
 
 type RemoveGroupReq struct {
@@ -56,12 +188,25 @@ type RemoveGroupReq struct {
 
 func (h *HttpHandler) RemoveGroup(c *gin.Context) {
 	var req RemoveGroupReq
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.matchmaker.RemoveGroup(req.ID)
+	// Group IDs are unique across queues
+	for _, queue := range h.queues {
+		if queue.RemoveGroup(req.ID) {
+			h.mu.Lock()
+			if group, ok := h.groups[req.ID]; ok {
+				h.cooldowns.Start(group.playerIDs)
+			}
+			h.mu.Unlock()
+			c.Status(http.StatusOK)
+			return
+		}
+	}
+	// Group may be in a queue owned by another instance
+	broadcast(c, h.node)
 
 	c.Status(http.StatusOK)
 }
@@ -72,12 +217,19 @@ type SetPlayerReadyReq struct {
 
 func (h *HttpHandler) SetPlayerReady(c *gin.Context) {
 	var req SetPlayerReadyReq
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := authorizePlayer(c.Request.Context(), req.PlayerId); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 
-	h.matchmaker.SetPlayerReady(req.PlayerId)
+	for _, queue := range h.queues {
+		queue.SetPlayerReady(req.PlayerId)
+	}
+	broadcast(c, h.node)
 
 	c.Status(http.StatusOK)
 }
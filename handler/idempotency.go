@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"goplay/auth"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// How long results of requests with idempotency keys are kept for retries
+	idempotencyRetention = time.Hour
+)
+
+type idempotentCall struct {
+	fingerprint [sha256.Size]byte
+	// Closed when the first call finishes
+	done    chan struct{}
+	result  any
+	expires time.Time
+}
+
+// Remembers calls by idempotency key, so retries get the result of the first call instead of repeating it.
+// Keys are kept in memory of the instance, retries which reach another instance run the call again.
+type Idempotency struct {
+	mu        sync.Mutex
+	calls     map[string]*idempotentCall
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewIdempotency() *Idempotency {
+	return &Idempotency{
+		calls:     make(map[string]*idempotentCall),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Runs the call once per key, retries with the same key wait for the first call and get its result.
+// Result is not kept if keep returns false for it, then the next retry runs the call again.
+// Fingerprint of the request must be the same for all calls with the key.
+func (i *Idempotency) do(ctx context.Context, key string, fingerprint [sha256.Size]byte, call func() any,
+	keep func(result any) bool) (result any, replayed bool, err error) {
+	for {
+		i.mu.Lock()
+		i.sweep()
+		existing, ok := i.calls[key]
+		if !ok {
+			first := &idempotentCall{fingerprint: fingerprint, done: make(chan struct{})}
+			i.calls[key] = first
+			i.mu.Unlock()
+
+			// Waiting retries are released even if the call panics
+			defer func() { i.finish(key, first, result, keep) }()
+			result = call()
+			return result, false, nil
+		}
+		i.mu.Unlock()
+
+		if existing.fingerprint != fingerprint {
+			return nil, false, newAPIError(codeIdempotencyKeyReused, "idempotency key is already used for another request")
+		}
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if existing.result != nil {
+			return existing.result, true, nil
+		}
+		// The first call failed, so this one runs again
+	}
+}
+
+func (i *Idempotency) finish(key string, call *idempotentCall, result any, keep func(result any) bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if result != nil && keep(result) {
+		call.result = result
+		call.expires = i.now().Add(idempotencyRetention)
+	} else {
+		delete(i.calls, key)
+	}
+	close(call.done)
+}
+
+// Drops expired results, must be called under lock
+func (i *Idempotency) sweep() {
+	now := i.now()
+	if now.Sub(i.lastSweep) < sweepInterval {
+		return
+	}
+	i.lastSweep = now
+
+	for key, call := range i.calls {
+		if !call.expires.IsZero() && call.expires.Before(now) {
+			delete(i.calls, key)
+		}
+	}
+}
+
+// Keys are scoped by caller, so callers can't get results of each other
+func idempotencyKey(ctx context.Context, key string) string {
+	if _, ok := auth.FromContext(ctx); ok {
+		return callerKey(ctx, "") + "/" + key
+	}
+
+	return key
+}
+
+// Response of the first request with idempotency key
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Requests with Idempotency-Key header are handled once, retries get the response of the first request.
+// Responses which may change on retry, like server errors and rate limits, are not kept.
+func Idempotent(idempotency *Idempotency) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := cachedBody(c)
+		if err != nil {
+			writeError(c, newAPIError(codeInvalidRequest, "%s", err))
+			c.Abort()
+			return
+		}
+		fingerprint := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(body)))
+
+		result, replayed, err := idempotency.do(c.Request.Context(), idempotencyKey(c.Request.Context(), key), fingerprint, func() any {
+			recorder := &responseRecorder{ResponseWriter: c.Writer}
+			c.Writer = recorder
+			c.Next()
+			return &recordedResponse{status: c.Writer.Status(), header: c.Writer.Header().Clone(), body: recorder.body.Bytes()}
+		}, func(result any) bool {
+			status := result.(*recordedResponse).status
+			return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+		})
+		if err != nil {
+			writeError(c, err)
+			c.Abort()
+			return
+		}
+		if !replayed {
+			return
+		}
+
+		response := result.(*recordedResponse)
+		for name, values := range response.header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Status(response.status)
+		c.Writer.Write(response.body)
+		c.Abort()
+	}
+}
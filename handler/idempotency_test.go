@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"goplay/cluster"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func sendIdempotent(t *testing.T, key, method, url string, body any) (*http.Response, string) {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyHeader, key)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Error(err)
+		return &http.Response{Header: http.Header{}}, ""
+	}
+	defer res.Body.Close()
+	out, _ := io.ReadAll(res.Body)
+
+	return res, string(out)
+}
+
+func TestIdempotentTickets(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil)
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL
+
+	ticket := map[string]any{"id": "1", "queue": "a", "playerIds": []int{1}}
+	res, first := sendIdempotent(t, "key-1", http.MethodPost, url+"/v1/tickets", ticket)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d, body %s, want ticket created", res.StatusCode, first)
+	}
+	res, retry := sendIdempotent(t, "key-1", http.MethodPost, url+"/v1/tickets", ticket)
+	if res.StatusCode != http.StatusCreated || retry != first || res.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got status %d, body %s of retry, want replayed %s", res.StatusCode, retry, first)
+	}
+	if depth := instance.queues["a"].Stats(100, 0).Depth; depth != 1 {
+		t.Errorf("got %d groups in queue after retry, want 1", depth)
+	}
+
+	res, body := sendIdempotent(t, "key-1", http.MethodPost, url+"/v1/tickets", map[string]any{"queue": "a", "playerIds": []int{2}})
+	var errRes testErrorResponse
+	json.Unmarshal([]byte(body), &errRes)
+	if res.StatusCode != http.StatusUnprocessableEntity || errRes.Error.Code != codeIdempotencyKeyReused {
+		t.Errorf("got status %d, body %s of reused key, want %d", res.StatusCode, body, http.StatusUnprocessableEntity)
+	}
+
+	// Retry without key is a new request for the same group
+	status, body := sendRequest(t, http.MethodPost, url+"/v1/tickets", ticket)
+	json.Unmarshal([]byte(body), &errRes)
+	if status != http.StatusConflict || errRes.Error.Code != codeAlreadyExists {
+		t.Errorf("got status %d, body %s of duplicate ticket, want %d", status, body, http.StatusConflict)
+	}
+}
+
+func TestIdempotentLegacyGroups(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil)
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL
+
+	// Retry waits for the response of the first request instead of queueing the group again
+	group := AddGroupReq{ID: "1", PlayerIDs: []int{1}, Queue: "a"}
+	responses := make(chan *http.Response, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			res, _ := sendIdempotent(t, "key-1", http.MethodPost, url+"/teams", group)
+			responses <- res
+		}()
+	}
+	for instance.queues["a"].Stats(100, 0).Depth == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Both requests are received before the group is cancelled
+	time.Sleep(50 * time.Millisecond)
+	if depth := instance.queues["a"].Stats(100, 0).Depth; depth != 1 {
+		t.Errorf("got %d groups in queue, want 1", depth)
+	}
+	sendRequest(t, http.MethodDelete, url+"/teams", RemoveGroupReq{ID: "1"})
+	replayed := 0
+	for i := 0; i < 2; i++ {
+		res := <-responses
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("got status %d of cancelled group, want %d", res.StatusCode, http.StatusOK)
+		}
+		if res.Header.Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != 1 {
+		t.Errorf("got %d replayed responses, want 1", replayed)
+	}
+
+	// Group ID which is already queued is rejected without key, unless the retry has the same players
+	statuses := make(chan int, 2)
+	retry := AddGroupReq{ID: "2", PlayerIDs: []int{2}, Queue: "a"}
+	go func() {
+		status, _ := sendRequest(t, http.MethodPost, url+"/teams", retry)
+		statuses <- status
+	}()
+	for instance.queues["a"].Stats(100, 0).Depth == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, _ := sendRequest(t, http.MethodPost, url+"/teams", AddGroupReq{ID: "2", PlayerIDs: []int{3}, Queue: "a"})
+	if status != http.StatusConflict {
+		t.Errorf("got status %d of duplicate group, want %d", status, http.StatusConflict)
+	}
+	go func() {
+		status, _ := sendRequest(t, http.MethodPost, url+"/teams", retry)
+		statuses <- status
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if depth := instance.queues["a"].Stats(100, 0).Depth; depth != 1 {
+		t.Errorf("got %d groups in queue after retry without key, want 1", depth)
+	}
+	sendRequest(t, http.MethodDelete, url+"/teams", RemoveGroupReq{ID: "2"})
+	for i := 0; i < 2; i++ {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("got status %d of cancelled group, want %d", status, http.StatusOK)
+		}
+	}
+}
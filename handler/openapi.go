@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+)
+
+// Spec of /v1 API, requests are validated against it
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func loadOpenAPI() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func ServeOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", openAPISpec)
+}
+
+// Rejects requests which don't match the spec with invalid_request error.
+// Requests to routes missing in the spec are passed as is.
+func ValidateRequests(doc *openapi3.T) (gin.HandlerFunc, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if errors.Is(err, routers.ErrPathNotFound) || errors.Is(err, routers.ErrMethodNotAllowed) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			writeError(c, newAPIError(codeInvalidRequest, "%s", err))
+			c.Abort()
+			return
+		}
+
+		// Body is read and replaced by the validator, so handlers can read it again
+		err = openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		})
+		if err != nil {
+			writeError(c, newAPIError(codeInvalidRequest, "%s", validationMessage(err)))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}, nil
+}
+
+// Keeps only the reason of the error without the dump of the schema
+func validationMessage(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return err.Error()
+	}
+
+	field := strings.Join(schemaErr.JSONPointer(), ".")
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) && requestErr.Parameter != nil {
+		field = requestErr.Parameter.Name
+	}
+	if field == "" {
+		return schemaErr.Reason
+	}
+
+	return field + ": " + schemaErr.Reason
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/auth"
+	"goplay/config"
+
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// How often buckets and cooldowns which are not needed anymore are dropped
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Token bucket limiter of requests by key, e.g. by player or caller
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// Returns nil if rate is 0, nil limiter allows everything
+func NewRateLimiter(cfg config.RateConfig) *RateLimiter {
+	if cfg.Rate == 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		rate:      cfg.Rate,
+		burst:     float64(cfg.Burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Takes a token of each key if all of them have one, otherwise returns how long to wait for them
+func (l *RateLimiter) Allow(keys ...string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: l.burst, updated: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+		b.updated = now
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/l.rate*float64(time.Second)))
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, key := range keys {
+		l.buckets[key].tokens--
+	}
+	return true, 0
+}
+
+// Drops buckets which are full again, they are the same as new ones
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Players who cancelled search recently, so they can't reroll matches by requeueing.
+// Cooldowns are kept by the instance which cancelled the search, other instances don't know them.
+type Cooldowns struct {
+	mu        sync.Mutex
+	duration  time.Duration
+	until     map[int]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// Returns nil if duration is 0, nil cooldowns never hold players
+func NewCooldowns(duration time.Duration) *Cooldowns {
+	if duration == 0 {
+		return nil
+	}
+
+	return &Cooldowns{
+		duration:  duration,
+		until:     make(map[int]time.Time),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (c *Cooldowns) Start(playerIDs []int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastSweep) >= sweepInterval {
+		c.lastSweep = now
+		for id, until := range c.until {
+			if !until.After(now) {
+				delete(c.until, id)
+			}
+		}
+	}
+
+	for _, id := range playerIDs {
+		c.until[id] = now.Add(c.duration)
+	}
+}
+
+// Returns the longest cooldown left of the players, 0 if none of them has it
+func (c *Cooldowns) Remaining(playerIDs []int) time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var remaining time.Duration
+	now := c.now()
+	for _, id := range playerIDs {
+		remaining = max(remaining, c.until[id].Sub(now))
+	}
+
+	return remaining
+}
+
+func cooldownError(remaining time.Duration) *apiError {
+	err := newAPIError(codeRequeueCooldown, "players cancelled search recently, they may queue again in %s", remaining.Round(time.Second))
+	err.retryAfter = remaining
+	return err
+}
+
+// Limits of ticket and ready check endpoints shared by REST and gRPC APIs.
+// Buckets are kept in memory of the instance, so each instance of the cluster limits only requests it serves.
+type RateLimits struct {
+	callers *RateLimiter
+	players *RateLimiter
+}
+
+func NewRateLimits(cfg config.RateLimitConfig) *RateLimits {
+	return &RateLimits{
+		callers: NewRateLimiter(cfg.Caller),
+		players: NewRateLimiter(cfg.Player),
+	}
+}
+
+func (l *RateLimits) allow(callerKey string, playerIDs []int) error {
+	if ok, wait := l.callers.Allow(callerKey); !ok {
+		return rateLimitError(wait)
+	}
+
+	keys := make([]string, len(playerIDs))
+	for i, id := range playerIDs {
+		keys[i] = strconv.Itoa(id)
+	}
+	if ok, wait := l.players.Allow(keys...); !ok {
+		return rateLimitError(wait)
+	}
+
+	return nil
+}
+
+func rateLimitError(wait time.Duration) *apiError {
+	err := newAPIError(codeRateLimited, "too many requests, retry in %s", wait.Round(time.Millisecond))
+	err.retryAfter = wait
+	return err
+}
+
+// Caller is known if authentication is enabled, otherwise it's the client address
+func callerKey(ctx context.Context, addr string) string {
+	caller, ok := auth.FromContext(ctx)
+	switch {
+	case !ok:
+		return "addr:" + addr
+	case caller.IsService():
+		return "service:" + caller.Service
+	default:
+		return "player:" + strconv.Itoa(caller.PlayerID)
+	}
+}
+
+// Players of the request, they are taken from path or body
+type playersFunc func(c *gin.Context) []int
+
+// Player IDs of the body of ticket and ready requests in both REST and legacy forms
+func bodyPlayers(c *gin.Context) []int {
+	var req struct {
+		PlayerIDs []int `json:"playerIds"`
+		PlayerID  int   `json:"playerId"`
+	}
+	// Invalid body is rejected by the handler
+	_ = c.ShouldBindBodyWith(&req, binding.JSON)
+	if req.PlayerID != 0 {
+		return append(req.PlayerIDs, req.PlayerID)
+	}
+
+	return req.PlayerIDs
+}
+
+func pathPlayer(c *gin.Context) []int {
+	id, err := strconv.Atoi(c.Param("playerId"))
+	if err != nil {
+		return nil
+	}
+
+	return []int{id}
+}
+
+// Rejects requests over the limit of the caller or any player of the request with 429 and Retry-After header
+func RateLimit(limits *RateLimits, players playersFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Request is already counted by the instance which forwarded it
+		if c.GetHeader(forwardedHeader) != "" {
+			c.Next()
+			return
+		}
+
+		var playerIDs []int
+		if players != nil {
+			playerIDs = players(c)
+		}
+		if err := limits.allow(callerKey(c.Request.Context(), c.ClientIP()), playerIDs); err != nil {
+			writeError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func writeRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+}
+
+// Limits unary gRPC calls like REST routes, must run after authentication
+func grpcRateLimit(limits *RateLimits, tickets *Tickets) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var playerIDs []int
+		switch req := req.(type) {
+		case *api.CreateTicketRequest:
+			for _, id := range req.PlayerIds {
+				playerIDs = append(playerIDs, int(id))
+			}
+		case *api.CancelTicketRequest:
+			playerIDs = tickets.players(req.Id)
+		case *api.AcceptMatchRequest:
+			playerIDs = []int{int(req.PlayerId)}
+		case *api.DeclineMatchRequest:
+			playerIDs = []int{int(req.PlayerId)}
+		default:
+			return handler(ctx, req)
+		}
+
+		var addr string
+		if p, ok := peer.FromContext(ctx); ok {
+			addr, _, _ = net.SplitHostPort(p.Addr.String())
+		}
+		if err := limits.allow(callerKey(ctx, addr), playerIDs); err != nil {
+			return nil, grpcError(err)
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"goplay/cluster"
+	"goplay/config"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(config.RateConfig{Rate: 1, Burst: 2})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("1"); !ok {
+			t.Fatalf("request %d within burst is limited", i)
+		}
+	}
+	ok, wait := limiter.Allow("1")
+	if ok || wait != time.Second {
+		t.Errorf("got allowed %v, wait %s, want request over burst limited for 1s", ok, wait)
+	}
+
+	// Request of a group is limited if any of its players is limited and takes no tokens then
+	if ok, _ := limiter.Allow("2", "1"); ok {
+		t.Error("request with limited player is allowed")
+	}
+	if ok, _ := limiter.Allow("2"); !ok {
+		t.Error("player is limited by a request which was not allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := limiter.Allow("1"); !ok {
+		t.Error("request is limited after the wait")
+	}
+
+	if ok, _ := NewRateLimiter(config.RateConfig{}).Allow("1"); !ok {
+		t.Error("request is limited without rate")
+	}
+}
+
+func createTicket(t *testing.T, url string, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	res, err := http.Post(url+"/v1/tickets", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestRateLimitTickets(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil, func(cfg *config.Config) {
+		cfg.RateLimit.Player = config.RateConfig{Rate: 0.01, Burst: 2}
+	})
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL
+
+	// Creation and cancellation count for each player of the ticket
+	res := createTicket(t, url, map[string]any{"id": "1", "queue": "a", "playerIds": []int{1, 2}})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d, want ticket created", res.StatusCode)
+	}
+	sendRequest(t, http.MethodDelete, url+"/v1/tickets/1", nil)
+
+	res = createTicket(t, url, map[string]any{"id": "2", "queue": "a", "playerIds": []int{2}})
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "100" {
+		t.Errorf("got status %d, Retry-After %q, want %d and 100", res.StatusCode, res.Header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	res = createTicket(t, url, map[string]any{"id": "3", "queue": "a", "playerIds": []int{3}})
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("got status %d of other player, want ticket created", res.StatusCode)
+	}
+}
+
+func TestRateLimitForwardedByClient(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil, func(cfg *config.Config) {
+		cfg.RateLimit.Caller = config.RateConfig{Rate: 0.01, Burst: 1}
+	})
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL + "/v1/tickets"
+
+	send := func(id string, sign func(req *http.Request, body []byte)) int {
+		body, _ := json.Marshal(map[string]any{"id": id, "queue": "a", "playerIds": []int{1}})
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		sign(req, body)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := send("1", func(req *http.Request, body []byte) {}); status != http.StatusCreated {
+		t.Fatalf("got status %d, want ticket created", status)
+	}
+	sendRequest(t, http.MethodDelete, url+"/1", nil)
+
+	// Mark of clients is dropped, so their requests are limited and not handled as forwarded
+	forged := []string{"true", strconv.FormatInt(time.Now().Unix(), 10) + ":" + strings.Repeat("0", 64)}
+	for _, value := range forged {
+		status := send("2", func(req *http.Request, body []byte) { req.Header.Set(forwardedHeader, value) })
+		if status != http.StatusTooManyRequests {
+			t.Errorf("got status %d of request marked %q by client, want %d", status, value, http.StatusTooManyRequests)
+		}
+	}
+
+	// Requests signed by an instance of the cluster were counted by it
+	status := send("2", func(req *http.Request, body []byte) { signForwarded(instance.node, req, body) })
+	if status != http.StatusCreated {
+		t.Errorf("got status %d of request forwarded by instance, want ticket created", status)
+	}
+}
+
+func TestRequeueCooldown(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil, func(cfg *config.Config) {
+		cfg.Server.LegacyRoutes = true
+		cfg.RateLimit.RequeueCooldown = config.Duration{Duration: time.Minute}
+	})
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL
+
+	createTicket(t, url, map[string]any{"id": "1", "queue": "a", "playerIds": []int{1}})
+	sendRequest(t, http.MethodDelete, url+"/v1/tickets/1", nil)
+	res := createTicket(t, url, map[string]any{"id": "2", "queue": "b", "playerIds": []int{1}})
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "60" {
+		t.Errorf("got status %d, Retry-After %q, want %d and 60", res.StatusCode, res.Header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	// Group of legacy API is cancelled while its request waits
+	cancelled := make(chan int, 1)
+	go func() {
+		status, _ := sendRequest(t, http.MethodPost, url+"/teams", AddGroupReq{ID: "3", PlayerIDs: []int{3}, Queue: "a"})
+		cancelled <- status
+	}()
+	for instance.queues["a"].Stats(100, 0).Depth == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sendRequest(t, http.MethodDelete, url+"/teams", RemoveGroupReq{ID: "3"})
+	<-cancelled
+
+	status, _ := sendRequest(t, http.MethodPost, url+"/teams", AddGroupReq{ID: "4", PlayerIDs: []int{3}, Queue: "a"})
+	if status != http.StatusTooManyRequests {
+		t.Errorf("got status %d of requeue, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitClientAddress(t *testing.T) {
+	for _, tc := range []struct {
+		proxies []string
+		status  int
+	}{
+		{nil, http.StatusTooManyRequests},
+		{[]string{"127.0.0.1"}, http.StatusCreated},
+	} {
+		instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil, func(cfg *config.Config) {
+			cfg.RateLimit.Caller = config.RateConfig{Rate: 0.01, Burst: 1}
+			cfg.Server.TrustedProxies = tc.proxies
+		})
+		instance.node.Refresh(context.Background())
+
+		var status int
+		for i, client := range []string{"10.0.0.1", "10.0.0.2"} {
+			body, _ := json.Marshal(map[string]any{"queue": "a", "playerIds": []int{i + 1}})
+			req, _ := http.NewRequest(http.MethodPost, instance.server.URL+"/v1/tickets", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Forwarded-For", client)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			status = res.StatusCode
+		}
+		if status != tc.status {
+			t.Errorf("trusted proxies %v: got status %d of the second client, want %d", tc.proxies, status, tc.status)
+		}
+	}
+}
@@ -1,17 +1,78 @@
 package handler
 
 import (
+	"goplay/auth"
 	"goplay/config"
 
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func StartRouter(cfg *config.Config, handler *HttpHandler) {
-	r := gin.Default()
+func NewServer(cfg *config.Config, handler *HttpHandler, v1 *V1Handler, admin *AdminHandler, metrics http.Handler,
+	authenticator auth.Authenticator, limits *RateLimits) *http.Server {
+	return &http.Server{
+		Addr:    cfg.Server.Port,
+		Handler: NewRouter(cfg, handler, v1, admin, metrics, authenticator, limits),
+	}
+}
+
+func NewRouter(cfg *config.Config, handler *HttpHandler, v1 *V1Handler, admin *AdminHandler, metrics http.Handler,
+	authenticator auth.Authenticator, limits *RateLimits) *gin.Engine {
+	r := gin.New()
+	// Client address is used for rate limits, so it's taken from headers only of trusted proxies.
+	// They are validated with config, invalid ones leave no proxy trusted.
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		_ = r.SetTrustedProxies(nil)
+	}
+	// Trace context of callers like lobby is extracted from request headers,
+	// so it's available to the logger
+	r.Use(gin.Recovery(), otelgin.Middleware("goplay"), RequestLogger(), VerifyForwarded(handler.node))
+
+	r.GET("/metrics", gin.WrapH(metrics))
 
-	r.POST("/teams", handler.AddGroup)
-	r.DELETE("/teams", handler.RemoveGroup)
-	r.POST("/players/ready", handler.SetPlayerReady)
+	r.GET("/v1/openapi.yaml", ServeOpenAPI)
+	// Authorization of players is checked by handlers, they know players of tickets
+	lobby := RequireService(cfg.Auth.LobbyService)
+	// Retries of ticket creation get the first response, they don't count against rate limits
+	idempotent := Idempotent(v1.service.idempotency)
+	versioned := r.Group("/v1", Authenticate(authenticator), v1.validate)
+	versioned.POST("/tickets", lobby, idempotent, RateLimit(limits, bodyPlayers), v1.CreateTicket)
+	versioned.GET("/tickets/:ticketId", v1.GetTicket)
+	versioned.DELETE("/tickets/:ticketId", RateLimit(limits, v1.ticketPlayers), v1.CancelTicket)
+	versioned.POST("/players/:playerId/accept", RateLimit(limits, pathPlayer), v1.AcceptMatch)
+	versioned.POST("/players/:playerId/decline", RateLimit(limits, pathPlayer), v1.DeclineMatch)
+	versioned.POST("/matches/:matchId/result", RequireService(), v1.ReportMatchResult)
+
+	// Deprecated, lobby should move to /v1 API
+	if cfg.Server.LegacyRoutes {
+		legacy := r.Group("", Deprecated(), Authenticate(authenticator))
+		legacy.POST("/teams", lobby, idempotent, RateLimit(limits, bodyPlayers), handler.AddGroup)
+		// Players of the group are not known here
+		legacy.DELETE("/teams", RequireService(), RateLimit(limits, nil), handler.RemoveGroup)
+		legacy.POST("/players/ready", RateLimit(limits, bodyPlayers), handler.SetPlayerReady)
+	}
+
+	// Admin API is available only if token is set
+	if cfg.Server.AdminToken != "" {
+		a := r.Group("/admin", AdminAuth(cfg.Server.AdminToken))
+		a.GET("/queues", admin.ListQueues)
+		a.GET("/queues/:queue", admin.GetQueue)
+		a.DELETE("/queues/:queue/tickets/:id", admin.CancelTicket)
+		a.POST("/queues/:queue/pause", admin.Pause)
+		a.POST("/queues/:queue/resume", admin.Resume)
+		a.POST("/queues/:queue/drain", admin.Drain)
+		a.POST("/queues/:queue/pass", admin.RunPass)
+	}
+
+	return r
+}
 
-	r.Run(cfg.Server.Port)
+// Marks responses of deprecated routes with Deprecation header
+func Deprecated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Next()
+	}
 }
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/cluster"
+	"goplay/config"
+	"goplay/matchmaker"
+	"goplay/repository"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Machine readable codes of API errors
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeUnauthenticated      = "unauthenticated"
+	codePermissionDenied     = "permission_denied"
+	codeUnknownQueue         = "unknown_queue"
+	codeNotFound             = "not_found"
+	codeAlreadyExists        = "already_exists"
+	codeAdmissionRejected    = "admission_rejected"
+	codeTicketInMatch        = "ticket_in_match"
+	codeQueueUnavailable     = "queue_unavailable"
+	codeRateLimited          = "rate_limited"
+	codeRequeueCooldown      = "requeue_cooldown"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeInternal             = "internal"
+)
+
+// Error of the ticket operation, each API maps its code to own status
+type apiError struct {
+	code    string
+	message string
+	// Admission rule which rejected the ticket
+	rule string
+	// When the request may be retried
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newAPIError(code, format string, args ...any) *apiError {
+	return &apiError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// Operations on tickets shared by gRPC and REST APIs
+type TicketService struct {
+	queues     map[string]matchmaker.Matchmaker
+	tickets    *Tickets
+	repository repository.Repository
+	// Instance in the cluster, nil if the matchmaker runs as a single instance
+	node      *cluster.Node
+	cooldowns *Cooldowns
+	// Results of ticket creation by idempotency key, shared by REST, legacy and gRPC APIs
+	idempotency *Idempotency
+}
+
+func NewTicketService(queues map[string]matchmaker.Matchmaker, tickets *Tickets, repository repository.Repository, node *cluster.Node,
+	cooldowns *Cooldowns) *TicketService {
+	return &TicketService{
+		queues:      queues,
+		tickets:     tickets,
+		repository:  repository,
+		node:        node,
+		cooldowns:   cooldowns,
+		idempotency: NewIdempotency(),
+	}
+}
+
+// Ticket to create, ID is generated if empty and default queue is used if queue is empty
+type ticketRequest struct {
+	ID        string
+	Queue     string
+	PlayerIDs []int
+	Roles     map[int][]string
+}
+
+// Returns queue of the request or error if it's unknown, empty queue name means default queue
+func (s *TicketService) queue(name string) (string, matchmaker.Matchmaker, error) {
+	if name == "" {
+		name = config.DefaultQueue
+	}
+	queue, ok := s.queues[name]
+	if !ok {
+		return "", nil, newAPIError(codeUnknownQueue, "unknown queue %s", name)
+	}
+
+	return name, queue, nil
+}
+
+// Returns address of the instance which owns the queue, empty if it's this instance
+func (s *TicketService) owner(name string) (string, error) {
+	name, _, err := s.queue(name)
+	if err != nil || s.node == nil {
+		return "", err
+	}
+
+	owner, err := s.node.Owner(name)
+	if err != nil {
+		return "", newAPIError(codeQueueUnavailable, "%s", err)
+	}
+	if owner == s.node.Addr() {
+		return "", nil
+	}
+
+	return owner, nil
+}
+
+func (s *TicketService) create(ctx context.Context, req ticketRequest) (*api.Ticket, error) {
+	name, queue, err := s.queue(req.Queue)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.PlayerIDs) == 0 {
+		return nil, newAPIError(codeInvalidRequest, "ticket must have players")
+	}
+	if remaining := s.cooldowns.Remaining(req.PlayerIDs); remaining > 0 {
+		return nil, cooldownError(remaining)
+	}
+	if req.ID == "" {
+		req.ID = newTicketID()
+	}
+
+	ticket := &api.Ticket{
+		Id:        req.ID,
+		Queue:     name,
+		PlayerIds: make([]int64, len(req.PlayerIDs)),
+		State:     api.TicketState_TICKET_STATE_QUEUED,
+		QueuedAt:  timestamppb.Now(),
+	}
+	for i, id := range req.PlayerIDs {
+		ticket.PlayerIds[i] = int64(id)
+	}
+	if !s.tickets.add(ticket) {
+		return nil, newAPIError(codeAlreadyExists, "ticket %s is already in search", req.ID)
+	}
+
+	found := make(chan string, 1)
+	cancelled := make(chan matchmaker.CancelReason, 1)
+	err = queue.AddGroup(ctx, req.ID, req.PlayerIDs, req.Roles, found, cancelled)
+	if err != nil {
+		s.tickets.remove(req.ID)
+		return nil, addGroupError(ctx, name, req, err)
+	}
+	go s.tickets.wait(req.ID, found, cancelled)
+
+	ticket, _, _ = s.tickets.get(req.ID)
+	return ticket, nil
+}
+
+func addGroupError(ctx context.Context, queue string, req ticketRequest, err error) error {
+	var admissionErr *matchmaker.AdmissionError
+	if errors.As(err, &admissionErr) {
+		apiErr := newAPIError(codeAdmissionRejected, "%s", err)
+		apiErr.rule = admissionErr.Rule
+		return apiErr
+	}
+	if errors.Is(err, matchmaker.ErrQueueDraining) || errors.Is(err, matchmaker.ErrQueueNotOwned) {
+		return newAPIError(codeQueueUnavailable, "%s", err)
+	}
+	if errors.Is(err, matchmaker.ErrGroupExists) {
+		return newAPIError(codeAlreadyExists, "%s", err)
+	}
+	if errors.Is(err, matchmaker.ErrInvalidGroup) {
+		return newAPIError(codeInvalidRequest, "%s", err)
+	}
+	if errors.Is(err, matchmaker.ErrPlayersNotFound) {
+		return newAPIError(codeNotFound, "%s", err)
+	}
+
+	slog.ErrorContext(ctx, "failed to add group", "group_id", req.ID, "player_ids", req.PlayerIDs,
+		"queue", queue, "error", err)
+	return newAPIError(codeInternal, "%s", err)
+}
+
+func newTicketID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *TicketService) get(ctx context.Context, id string) (*api.Ticket, error) {
+	ticket, _, ok := s.tickets.get(id)
+	if !ok {
+		return nil, newAPIError(codeNotFound, "ticket %s is not found", id)
+	}
+	if err := authorizeTicket(ctx, ticket); err != nil {
+		return nil, err
+	}
+
+	return ticket, nil
+}
+
+func (s *TicketService) cancel(ctx context.Context, id string) (*api.Ticket, error) {
+	ticket, changed, ok := s.tickets.get(id)
+	if !ok {
+		return nil, newAPIError(codeNotFound, "ticket %s is not found", id)
+	}
+	if err := authorizeTicket(ctx, ticket); err != nil {
+		return nil, err
+	}
+	if finished(ticket) {
+		return ticket, nil
+	}
+
+	if !s.queues[ticket.Queue].RemoveGroup(id) {
+		return nil, newAPIError(codeTicketInMatch, "ticket %s is in a match", id)
+	}
+	s.cooldowns.Start(s.tickets.players(id))
+
+	// Ticket is updated when matchmaker notifies about cancellation
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+	}
+	ticket, _, _ = s.tickets.get(id)
+	return ticket, nil
+}
+
+func (s *TicketService) accept(ctx context.Context, playerID int) error {
+	if err := authorizePlayer(ctx, playerID); err != nil {
+		return err
+	}
+	for _, queue := range s.queues {
+		queue.SetPlayerReady(playerID)
+	}
+
+	return nil
+}
+
+func (s *TicketService) decline(ctx context.Context, playerID int) error {
+	if err := authorizePlayer(ctx, playerID); err != nil {
+		return err
+	}
+	for _, queue := range s.queues {
+		queue.SetPlayerDeclined(playerID)
+	}
+
+	return nil
+}
+
+func (s *TicketService) reportResult(ctx context.Context, result repository.MatchResult) error {
+	if len(result.Teams) < 2 {
+		return newAPIError(codeInvalidRequest, "match must have at least 2 teams")
+	}
+	if result.MatchID == "" {
+		return newAPIError(codeInvalidRequest, "match ID is required")
+	}
+	result.PlayedAt = time.Now()
+
+	err := s.repository.SaveMatchResult(ctx, result)
+	if errors.Is(err, repository.ErrMatchExists) {
+		return newAPIError(codeAlreadyExists, "%s", err)
+	}
+	if err != nil {
+		return newAPIError(codeInternal, "%s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/matchmaker"
+
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// How long finished tickets can be read
+const ticketRetention = 10 * time.Minute
+
+type ticketEntry struct {
+	ticket *api.Ticket
+	// Closed and replaced on each change of the ticket
+	changed chan struct{}
+}
+
+// Keeps tickets created by gRPC and REST APIs, so they can be read and watched.
+// It listens to the matchmakers to know when tickets get into a match.
+type Tickets struct {
+	mu      sync.Mutex
+	tickets map[string]*ticketEntry
+}
+
+func NewTickets() *Tickets {
+	return &Tickets{
+		tickets: make(map[string]*ticketEntry),
+	}
+}
+
+func finished(ticket *api.Ticket) bool {
+	return ticket.State == api.TicketState_TICKET_STATE_FOUND || ticket.State == api.TicketState_TICKET_STATE_CANCELLED
+}
+
+// Returns false if there is an unfinished ticket with the same ID
+func (t *Tickets) add(ticket *api.Ticket) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if entry, ok := t.tickets[ticket.Id]; ok && !finished(entry.ticket) {
+		return false
+	}
+	t.tickets[ticket.Id] = &ticketEntry{ticket: ticket, changed: make(chan struct{})}
+
+	return true
+}
+
+// Removes ticket which was not accepted by the matchmaker
+func (t *Tickets) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.tickets, id)
+}
+
+// Returns copy of the ticket and channel which is closed when the ticket changes
+func (t *Tickets) get(id string) (*api.Ticket, <-chan struct{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.tickets[id]
+	if !ok {
+		return nil, nil, false
+	}
+
+	return proto.Clone(entry.ticket).(*api.Ticket), entry.changed, true
+}
+
+// Returns players of the ticket, nil if it's not found
+func (t *Tickets) players(id string) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.tickets[id]
+	if !ok {
+		return nil
+	}
+	players := make([]int, len(entry.ticket.PlayerIds))
+	for i, id := range entry.ticket.PlayerIds {
+		players[i] = int(id)
+	}
+
+	return players
+}
+
+// Changes unfinished ticket, finished one is kept for a while to be read
+func (t *Tickets) update(id string, change func(ticket *api.Ticket)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.tickets[id]
+	if !ok || finished(entry.ticket) {
+		return
+	}
+
+	change(entry.ticket)
+	close(entry.changed)
+	entry.changed = make(chan struct{})
+
+	if finished(entry.ticket) {
+		time.AfterFunc(ticketRetention, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if t.tickets[id] == entry {
+				delete(t.tickets, id)
+			}
+		})
+	}
+}
+
+// Updates the ticket when it leaves the queue
+func (t *Tickets) wait(id string, found <-chan string, cancelled <-chan matchmaker.CancelReason) {
+	select {
+	case serverID := <-found:
+		t.update(id, func(ticket *api.Ticket) {
+			ticket.State = api.TicketState_TICKET_STATE_FOUND
+			ticket.ServerId = serverID
+		})
+	case reason := <-cancelled:
+		t.update(id, func(ticket *api.Ticket) {
+			ticket.State = api.TicketState_TICKET_STATE_CANCELLED
+			ticket.CancelReason = string(reason)
+		})
+	}
+}
+
+// Listener of the named queue, which also registers tickets restored after restart
+func (t *Tickets) Queue(name string) matchmaker.TicketListener {
+	return queueTickets{Tickets: t, queue: name}
+}
+
+type queueTickets struct {
+	*Tickets
+	queue string
+}
+
+func (q queueTickets) TicketRestored(record matchmaker.TicketRecord, found <-chan string, cancelled <-chan matchmaker.CancelReason) {
+	ticket := &api.Ticket{
+		Id:        record.ID,
+		Queue:     q.queue,
+		PlayerIds: make([]int64, len(record.PlayerIDs)),
+		State:     api.TicketState_TICKET_STATE_QUEUED,
+		QueuedAt:  timestamppb.New(record.QueuedAt),
+	}
+	for i, id := range record.PlayerIDs {
+		ticket.PlayerIds[i] = int64(id)
+	}
+	if q.add(ticket) {
+		go q.wait(record.ID, found, cancelled)
+	}
+}
+
+func (t *Tickets) TicketQueued(id string) {
+	t.update(id, func(ticket *api.Ticket) {
+		ticket.State = api.TicketState_TICKET_STATE_QUEUED
+		ticket.MatchId = ""
+	})
+}
+
+func (t *Tickets) TicketsMatching(matchID string, ids []string) {
+	for _, id := range ids {
+		t.update(id, func(ticket *api.Ticket) {
+			ticket.State = api.TicketState_TICKET_STATE_MATCHING
+			ticket.MatchId = matchID
+		})
+	}
+}
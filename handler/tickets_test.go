@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/matchmaker"
+
+	"testing"
+	"time"
+)
+
+func TestRestoredTicket(t *testing.T) {
+	tickets := NewTickets()
+	found := make(chan string, 1)
+	queuedAt := time.Now().Add(-time.Minute)
+	tickets.Queue("ranked").TicketRestored(matchmaker.TicketRecord{ID: "1", PlayerIDs: []int{1, 2}, QueuedAt: queuedAt},
+		found, make(chan matchmaker.CancelReason, 1))
+
+	ticket, changed, ok := tickets.get("1")
+	if !ok {
+		t.Fatal("restored ticket is not found")
+	}
+	if ticket.Queue != "ranked" || ticket.State != api.TicketState_TICKET_STATE_QUEUED || len(ticket.PlayerIds) != 2 ||
+		!ticket.QueuedAt.AsTime().Equal(queuedAt) {
+		t.Errorf("got ticket %v, want queued ticket of ranked queue", ticket)
+	}
+
+	found <- "server"
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("ticket is not updated when match is found")
+	}
+	if ticket, _, _ := tickets.get("1"); ticket.State != api.TicketState_TICKET_STATE_FOUND || ticket.ServerId != "server" {
+		t.Errorf("got ticket %v, want found on server", ticket)
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"goplay/api"
+	"goplay/repository"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Serves /v1 REST API, requests are validated against openapi.yaml before they get here
+type V1Handler struct {
+	service *TicketService
+	// Validates requests against the spec
+	validate gin.HandlerFunc
+}
+
+func NewV1Handler(service *TicketService) (*V1Handler, error) {
+	doc, err := loadOpenAPI()
+	if err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+	validate, err := ValidateRequests(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &V1Handler{
+		service:  service,
+		validate: validate,
+	}, nil
+}
+
+var httpStatuses = map[string]int{
+	codeInvalidRequest:       http.StatusBadRequest,
+	codeUnauthenticated:      http.StatusUnauthorized,
+	codePermissionDenied:     http.StatusForbidden,
+	codeUnknownQueue:         http.StatusBadRequest,
+	codeNotFound:             http.StatusNotFound,
+	codeAlreadyExists:        http.StatusConflict,
+	codeAdmissionRejected:    http.StatusForbidden,
+	codeTicketInMatch:        http.StatusConflict,
+	codeQueueUnavailable:     http.StatusServiceUnavailable,
+	codeRateLimited:          http.StatusTooManyRequests,
+	codeRequeueCooldown:      http.StatusTooManyRequests,
+	codeIdempotencyKeyReused: http.StatusUnprocessableEntity,
+	codeInternal:             http.StatusInternalServerError,
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+func writeError(c *gin.Context, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = newAPIError(codeInternal, "%s", err)
+	}
+	if apiErr.retryAfter > 0 {
+		writeRetryAfter(c, apiErr.retryAfter)
+	}
+
+	c.JSON(httpStatuses[apiErr.code], gin.H{"error": errorBody{Code: apiErr.code, Message: apiErr.message, Rule: apiErr.rule}})
+}
+
+type ticketResponse struct {
+	ID           string    `json:"id"`
+	Queue        string    `json:"queue"`
+	PlayerIDs    []int64   `json:"playerIds"`
+	State        string    `json:"state"`
+	QueuedAt     time.Time `json:"queuedAt"`
+	MatchID      string    `json:"matchId,omitempty"`
+	ServerID     string    `json:"serverId,omitempty"`
+	CancelReason string    `json:"cancelReason,omitempty"`
+}
+
+func newTicketResponse(ticket *api.Ticket) ticketResponse {
+	return ticketResponse{
+		ID:        ticket.Id,
+		Queue:     ticket.Queue,
+		PlayerIDs: ticket.PlayerIds,
+		// TICKET_STATE_QUEUED is queued
+		State:        strings.ToLower(strings.TrimPrefix(ticket.State.String(), "TICKET_STATE_")),
+		QueuedAt:     ticket.QueuedAt.AsTime(),
+		MatchID:      ticket.MatchId,
+		ServerID:     ticket.ServerId,
+		CancelReason: ticket.CancelReason,
+	}
+}
+
+type createTicketReq struct {
+	ID        string           `json:"id"`
+	Queue     string           `json:"queue"`
+	PlayerIDs []int            `json:"playerIds"`
+	Roles     map[int][]string `json:"roles"`
+}
+
+func (h *V1Handler) CreateTicket(c *gin.Context) {
+	var req createTicketReq
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		writeError(c, newAPIError(codeInvalidRequest, "%s", err))
+		return
+	}
+
+	owner, err := h.service.owner(req.Queue)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	// Ticket is kept by the instance which owns its queue
+	if owner != "" && c.GetHeader(forwardedHeader) == "" {
+		forward(c, h.service.node, owner)
+		return
+	}
+
+	ticket, err := h.service.create(c.Request.Context(), ticketRequest{
+		ID:        req.ID,
+		Queue:     req.Queue,
+		PlayerIDs: req.PlayerIDs,
+		Roles:     req.Roles,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newTicketResponse(ticket))
+}
+
+func (h *V1Handler) GetTicket(c *gin.Context) {
+	ticket, err := h.service.get(c.Request.Context(), c.Param("ticketId"))
+	if err != nil {
+		h.writeTicketError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newTicketResponse(ticket))
+}
+
+func (h *V1Handler) CancelTicket(c *gin.Context) {
+	ticket, err := h.service.cancel(c.Request.Context(), c.Param("ticketId"))
+	if err != nil {
+		h.writeTicketError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newTicketResponse(ticket))
+}
+
+// Players of the ticket of the path for rate limits
+func (h *V1Handler) ticketPlayers(c *gin.Context) []int {
+	return h.service.tickets.players(c.Param("ticketId"))
+}
+
+// Ticket which is not found may be kept by another instance
+func (h *V1Handler) writeTicketError(c *gin.Context, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.code == codeNotFound && findOnPeers(c, h.service.node) {
+		return
+	}
+
+	writeError(c, err)
+}
+
+func (h *V1Handler) AcceptMatch(c *gin.Context) {
+	// Player ID is validated by the spec
+	playerID, _ := strconv.Atoi(c.Param("playerId"))
+	if err := h.service.accept(c.Request.Context(), playerID); err != nil {
+		writeError(c, err)
+		return
+	}
+	broadcast(c, h.service.node)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *V1Handler) DeclineMatch(c *gin.Context) {
+	playerID, _ := strconv.Atoi(c.Param("playerId"))
+	if err := h.service.decline(c.Request.Context(), playerID); err != nil {
+		writeError(c, err)
+		return
+	}
+	broadcast(c, h.service.node)
+
+	c.Status(http.StatusNoContent)
+}
+
+type matchResultReq struct {
+	Teams []struct {
+		PlayerIDs []int `json:"playerIds"`
+		Won       bool  `json:"won"`
+	} `json:"teams"`
+}
+
+func (h *V1Handler) ReportMatchResult(c *gin.Context) {
+	var req matchResultReq
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		writeError(c, newAPIError(codeInvalidRequest, "%s", err))
+		return
+	}
+
+	result := repository.MatchResult{
+		MatchID: c.Param("matchId"),
+		Teams:   make([][]int, len(req.Teams)),
+		Won:     make([]bool, len(req.Teams)),
+	}
+	for i, team := range req.Teams {
+		result.Teams[i] = team.PlayerIDs
+		result.Won[i] = team.Won
+	}
+
+	err := h.service.reportResult(c.Request.Context(), result)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"goplay/cluster"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+type testErrorResponse struct {
+	Error errorBody
+}
+
+func TestV1Tickets(t *testing.T) {
+	instance := newTestInstance(t, cluster.NewLocalCoordinator(), nil, nil)
+	instance.node.Refresh(context.Background())
+	url := instance.server.URL + "/v1"
+
+	status, body := sendRequest(t, http.MethodPost, url+"/tickets", map[string]any{"id": "1", "queue": "a", "playerIds": []int{1}})
+	var ticket ticketResponse
+	json.Unmarshal([]byte(body), &ticket)
+	if status != http.StatusCreated || ticket.ID != "1" || ticket.State != "queued" {
+		t.Fatalf("got status %d, ticket %s, want queued ticket created", status, body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		body   any
+		status int
+		code   string
+	}{
+		{"duplicate", map[string]any{"id": "1", "queue": "a", "playerIds": []int{2}}, http.StatusConflict, codeAlreadyExists},
+		{"no players", map[string]any{"queue": "a", "playerIds": []int{}}, http.StatusBadRequest, codeInvalidRequest},
+		{"unknown field", map[string]any{"queue": "a", "playerIds": []int{2}, "team": 1}, http.StatusBadRequest, codeInvalidRequest},
+		{"unknown queue", map[string]any{"queue": "c", "playerIds": []int{2}}, http.StatusBadRequest, codeUnknownQueue},
+	} {
+		status, body := sendRequest(t, http.MethodPost, url+"/tickets", tc.body)
+		var res testErrorResponse
+		json.Unmarshal([]byte(body), &res)
+		if status != tc.status || res.Error.Code != tc.code || res.Error.Message == "" {
+			t.Errorf("%s: got status %d, body %s, want status %d, code %s", tc.name, status, body, tc.status, tc.code)
+		}
+	}
+
+	status, _ = sendRequest(t, http.MethodGet, url+"/tickets/1", nil)
+	if status != http.StatusOK {
+		t.Errorf("got status %d of existing ticket, want %d", status, http.StatusOK)
+	}
+	status, _ = sendRequest(t, http.MethodGet, url+"/tickets/2", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("got status %d of missing ticket, want %d", status, http.StatusNotFound)
+	}
+	status, _ = sendRequest(t, http.MethodPost, url+"/players/abc/accept", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("got status %d of invalid player ID, want %d", status, http.StatusBadRequest)
+	}
+
+	status, body = sendRequest(t, http.MethodDelete, url+"/tickets/1", nil)
+	json.Unmarshal([]byte(body), &ticket)
+	if status != http.StatusOK || ticket.State != "cancelled" || ticket.CancelReason != "request" {
+		t.Errorf("got status %d, ticket %s, want ticket cancelled by request", status, body)
+	}
+
+	status, _ = sendRequest(t, http.MethodGet, url+"/openapi.yaml", nil)
+	if status != http.StatusOK {
+		t.Errorf("got status %d of spec, want %d", status, http.StatusOK)
+	}
+}
+
+func TestV1ClusterTickets(t *testing.T) {
+	coordinator := cluster.NewLocalCoordinator()
+	first := newTestInstance(t, coordinator, []string{"a"}, nil)
+	second := newTestInstance(t, coordinator, nil, nil)
+	first.node.Refresh(context.Background())
+	second.node.Refresh(context.Background())
+	first.node.Refresh(context.Background())
+
+	// Ticket is created by the owner of the queue and found from the other instance
+	status, body := sendRequest(t, http.MethodPost, first.server.URL+"/v1/tickets", map[string]any{"id": "1", "queue": "b", "playerIds": []int{1}})
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, body %s, want ticket created", status, body)
+	}
+	if second.queues["b"].Stats(100, 0).Depth != 1 {
+		t.Error("ticket is not queued by owner of the queue")
+	}
+
+	status, body = sendRequest(t, http.MethodGet, first.server.URL+"/v1/tickets/1", nil)
+	var ticket ticketResponse
+	json.Unmarshal([]byte(body), &ticket)
+	if status != http.StatusOK || ticket.Queue != "b" {
+		t.Errorf("got status %d, ticket %s, want ticket from other instance", status, body)
+	}
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"goplay/config"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	var out bytes.Buffer
+	logger := Setup(config.LogConfig{Level: "warn", Format: "json"}, &out)
+
+	logger.Info("skipped")
+	if out.Len() != 0 {
+		t.Errorf("got %q, want records below warn level skipped", out.String())
+	}
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	logger.With("queue", "ranked").WarnContext(ctx, "match is not accepted", "match_id", "m1")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("got %q, want JSON record: %s", out.String(), err)
+	}
+	want := map[string]string{
+		"msg":      "match is not accepted",
+		"queue":    "ranked",
+		"match_id": "m1",
+		"trace_id": spanContext.TraceID().String(),
+		"span_id":  spanContext.SpanID().String(),
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("got %s=%v, want %q", key, record[key], value)
+		}
+	}
+}
@@ -1,26 +1,284 @@
 package main
 
 import (
+	"goplay/auth"
+	"goplay/cluster"
 	"goplay/config"
 	"goplay/handler"
+	"goplay/logging"
 	"goplay/matchmaker"
+	"goplay/metrics"
 	"goplay/repository"
+	"goplay/state"
+	"goplay/tracing"
 
-	"log"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"google.golang.org/grpc"
 )
 
 func main() {
-	cfg := config.NewConfig()
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "validate" {
+		os.Exit(validateConfig(os.Args[3:]))
+	}
+
+	args := os.Args[1:]
+	cfg, err := config.NewConfig(args)
+	if err != nil {
+		fatal("could not load config", err)
+	}
+	logging.Setup(cfg.Log, os.Stderr)
+	if cfg.Server.ServerManagerAddr == "" {
+		slog.Warn("server.serverManagerAddr is not set, servers can't be allocated for matches")
+	}
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		fatal("could not set up tracing", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	db, err := repository.OpenDatabase(cfg)
 	if err != nil {
-		log.Fatalf("Could not connect to DB: %s", err)
+		fatal("could not connect to DB", err)
 	}
 	defer db.Close()
 
-	rep := repository.NewSQLRepository(db)
-	mm := matchmaker.NewMatchmaker(rep, cfg, matchmaker.RequestServer)
-	hdl := handler.NewHttpHandler(mm)
+	prom := metrics.NewPrometheus()
+	rep := repository.NewInstrumentedRepository(repository.NewSQLRepository(db), prom)
+	tickets := handler.NewTickets()
+	queues := make(map[string]matchmaker.Matchmaker)
+	for name, params := range cfg.QueueConfigs() {
+		queueCfg := *cfg
+		queueCfg.Matchmaker = *params
+		opts := []matchmaker.Option{matchmaker.WithMetrics(prom.Queue(name)), matchmaker.WithLogger(slog.With("queue", name)),
+			matchmaker.WithTicketListener(tickets.Queue(name))}
+		if cfg.State.Dir != "" {
+			store, err := state.OpenFileStore(filepath.Join(cfg.State.Dir, name+".jsonl"), cfg.State.Sync)
+			if err != nil {
+				fatal("could not open queue state", err)
+			}
+			defer store.Close()
+			opts = append(opts, matchmaker.WithStateStore(store))
+		}
+		queues[name] = matchmaker.NewMatchmaker(rep, &queueCfg, matchmaker.RequestServer, opts...)
+		if err := queues[name].Restore(context.Background()); err != nil {
+			fatal("could not restore queue", err)
+		}
+	}
+
+	var node *cluster.Node
+	stopNode := func() {}
+	if cfg.Cluster.Addr != "" {
+		node = newNode(cfg, db, queues)
+		nodeCtx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			node.Run(nodeCtx)
+			close(stopped)
+		}()
+		stopNode = func() {
+			cancel()
+			<-stopped
+		}
+	}
+	for name := range queues {
+		go queues[name].Run()
+	}
+	cooldowns := handler.NewCooldowns(cfg.RateLimit.RequeueCooldown.Duration)
+	limits := handler.NewRateLimits(cfg.RateLimit)
+	hdl := handler.NewHttpHandler(queues, node, cooldowns)
+	service := handler.NewTicketService(queues, tickets, rep, node, cooldowns)
+	v1, err := handler.NewV1Handler(service)
+	if err != nil {
+		fatal("could not create API handler", err)
+	}
+	admin := handler.NewAdminHandler(queues)
+	authenticator, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		fatal("could not load auth keys", err)
+	}
+	if authenticator == nil {
+		for _, addr := range []string{cfg.Server.Port, cfg.Server.GrpcPort} {
+			if addr != "" && !loopback(addr) {
+				slog.Error("authentication is disabled on non-loopback address, anyone who reaches it can queue, "+
+					"accept and cancel matches for any player", "addr", addr)
+			}
+		}
+	}
+
+	go config.WatchConfig(args, cfg, func(newCfg *config.Config) {
+		// Queues can't be added or removed without restart
+		for name, params := range newCfg.QueueConfigs() {
+			if queue, ok := queues[name]; ok {
+				queue.UpdateParams(params)
+			} else {
+				slog.Warn("queue is added to config, restart is required to start it", "queue", name)
+			}
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	server := handler.NewServer(cfg, hdl, v1, admin, prom.Handler(), authenticator, limits)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("server failed", err)
+		}
+	}()
+
+	var grpcServer *grpc.Server
+	if cfg.Server.GrpcPort != "" {
+		grpcServer = handler.NewGrpcServer(handler.NewGrpcHandler(service), authenticator, cfg.Auth.LobbyService, limits)
+		listener, err := net.Listen("tcp", cfg.Server.GrpcPort)
+		if err != nil {
+			fatal("could not listen gRPC port", err)
+		}
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				fatal("gRPC server failed", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	// Another signal kills the process at once
+	stop()
+	shutdown(cfg, server, grpcServer, queues, stopNode)
+}
+
+// Stops taking tickets, lets matches in progress finish and cancels the rest of tickets.
+// DB, state stores and tracing are closed by deferred calls after that.
+func shutdown(cfg *config.Config, server *http.Server, grpcServer *grpc.Server, queues map[string]matchmaker.Matchmaker, stopNode func()) {
+	slog.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	// Other instances take leases of the queues and new tickets
+	stopNode()
+
+	var wg sync.WaitGroup
+	for name, queue := range queues {
+		wg.Add(1)
+		go func(name string, queue matchmaker.Matchmaker) {
+			defer wg.Done()
+			if err := queue.Shutdown(ctx); err != nil {
+				slog.Warn("queue is not shut down cleanly", "queue", name, "error", err)
+			}
+		}(name, queue)
+	}
+	wg.Wait()
+
+	// Waits for responses to cancelled tickets
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("requests are not finished before shutdown", "error", err)
+		server.Close()
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			slog.Warn("gRPC requests are not finished before shutdown")
+			grpcServer.Stop()
+		}
+	}
+}
+
+// Instance takes only tickets of queues it holds leases of. Ownership is kept apart from the state set by admin API,
+// so a paused or draining queue stays so when the instance takes its lease.
+func newNode(cfg *config.Config, db *sql.DB, queues map[string]matchmaker.Matchmaker) *cluster.Node {
+	names := make([]string, 0, len(queues))
+	for name, queue := range queues {
+		names = append(names, name)
+		queue.SetOwned(false)
+	}
+	sort.Strings(names)
+
+	return cluster.NewNode(cfg.Cluster.Addr, cluster.NewSQLCoordinator(db), names, cfg.Cluster.LeaseTTL.Duration,
+		cluster.WithShards(cfg.Cluster.Queues),
+		cluster.WithSecret(cfg.Cluster.Secret),
+		cluster.OnOwnershipChange(func(shard string, owned bool) {
+			queues[shard].SetOwned(owned)
+		}))
+}
+
+// Returns nil if authentication is disabled
+func newAuthenticator(cfg config.AuthConfig) (auth.Authenticator, error) {
+	if !cfg.Enabled() {
+		slog.Warn("authentication is disabled, any caller can queue any player")
+		return nil, nil
+	}
+
+	var chain auth.Chain
+	if len(cfg.Services) > 0 {
+		chain = append(chain, auth.NewServiceAuthenticator(cfg.Services, cfg.Audience))
+	}
+	if cfg.JWKSFile != "" {
+		players, err := auth.NewPlayerAuthenticator(cfg.JWKSFile, cfg.Issuer, cfg.Audience)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, players)
+	}
+
+	return chain, nil
+}
+
+// Address without host listens on all interfaces
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Logs error and exits, deferred calls are not run
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// Prints effective config with secrets redacted, returns exit code
+func validateConfig(args []string) int {
+	cfg, err := config.NewConfig(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
 
-	handler.StartRouter(cfg, hdl)
+	return 0
 }
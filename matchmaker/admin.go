@@ -0,0 +1,246 @@
+package matchmaker
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+type QueueState string
+
+const (
+	// Groups are accepted and matched
+	QueueRunning QueueState = "running"
+	// Groups are accepted, but matches are made only by manual passes
+	QueuePaused QueueState = "paused"
+	// New groups are rejected, groups in search are still matched
+	QueueDraining QueueState = "draining"
+)
+
+var (
+	ErrQueueDraining = errors.New("queue is draining, new groups are not accepted")
+	ErrQueueNotOwned = errors.New("queue is owned by another instance")
+)
+
+// Snapshot of the queue for operators
+type QueueStats struct {
+	State QueueState `json:"state"`
+	// False if another instance of the cluster owns the queue
+	Owned bool `json:"owned"`
+	// Number of groups in search
+	Depth       int               `json:"depth"`
+	ByRating    []RatingBucket    `json:"byRating"`
+	BySize      map[int]int       `json:"bySize"`
+	Oldest      []TicketInfo      `json:"oldest"`
+	ReadyChecks []ReadyCheckInfo  `json:"readyChecks"`
+	Penalized   []PenalizedPlayer `json:"penalized"`
+}
+
+// Number of groups with average rating in [Min, Max]
+type RatingBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+type TicketInfo struct {
+	ID        string    `json:"id"`
+	PlayerIDs []int     `json:"playerIds"`
+	AvgRating int       `json:"avgRating"`
+	Pool      string    `json:"pool,omitempty"`
+	QueuedAt  time.Time `json:"queuedAt"`
+	Requeued  bool      `json:"requeued"`
+}
+
+type ReadyCheckInfo struct {
+	PlayerIDs      []int     `json:"playerIds"`
+	ReadyPlayerIDs []int     `json:"readyPlayerIds"`
+	Deadline       time.Time `json:"deadline"`
+}
+
+type PenalizedPlayer struct {
+	ID    int       `json:"id"`
+	Until time.Time `json:"until"`
+}
+
+// Collects stats of the queue, groups are counted in rating buckets of the given width
+// and up to the given number of the oldest groups is listed
+func (m *matchmaker) Stats(bucketWidth, oldest int) QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if bucketWidth <= 0 {
+		bucketWidth = 100
+	}
+
+	stats := QueueStats{
+		State:       m.state,
+		Owned:       !m.notOwned,
+		Depth:       m.searchQueue.Len(),
+		ByRating:    make([]RatingBucket, 0),
+		BySize:      make(map[int]int),
+		Oldest:      make([]TicketInfo, 0, oldest),
+		ReadyChecks: make([]ReadyCheckInfo, 0, len(m.readyChecks)),
+		Penalized:   make([]PenalizedPlayer, 0, len(m.penalizedPlayers)),
+	}
+
+	buckets := make(map[int]int)
+	groups := make([]*Group, 0, m.searchQueue.Len())
+	for e := m.searchQueue.Front(); e != nil; e = e.Next() {
+		group := e.Value.(*Group)
+		groups = append(groups, group)
+		stats.BySize[group.Size]++
+		// Floor division, so negative ratings get their own buckets
+		bucket := group.AvgRating / bucketWidth
+		if group.AvgRating < 0 && group.AvgRating%bucketWidth != 0 {
+			bucket--
+		}
+		buckets[bucket]++
+	}
+
+	for bucket, count := range buckets {
+		stats.ByRating = append(stats.ByRating, RatingBucket{
+			Min:   bucket * bucketWidth,
+			Max:   (bucket+1)*bucketWidth - 1,
+			Count: count,
+		})
+	}
+	sort.Slice(stats.ByRating, func(i, j int) bool {
+		return stats.ByRating[i].Min < stats.ByRating[j].Min
+	})
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].queuedAt.Before(groups[j].queuedAt)
+	})
+	for i := 0; i < len(groups) && i < oldest; i++ {
+		stats.Oldest = append(stats.Oldest, ticketInfo(groups[i]))
+	}
+
+	for check := range m.readyChecks {
+		stats.ReadyChecks = append(stats.ReadyChecks, check.info())
+	}
+	sort.Slice(stats.ReadyChecks, func(i, j int) bool {
+		return stats.ReadyChecks[i].Deadline.Before(stats.ReadyChecks[j].Deadline)
+	})
+
+	penalty := time.Duration(m.params.PenaltySeconds) * time.Second
+	for id, penaltyTime := range m.penalizedPlayers {
+		if time.Since(penaltyTime) < penalty {
+			stats.Penalized = append(stats.Penalized, PenalizedPlayer{ID: id, Until: penaltyTime.Add(penalty)})
+		}
+	}
+	sort.Slice(stats.Penalized, func(i, j int) bool {
+		return stats.Penalized[i].ID < stats.Penalized[j].ID
+	})
+
+	return stats
+}
+
+func ticketInfo(group *Group) TicketInfo {
+	ids := make([]int, len(group.Players))
+	for i, player := range group.Players {
+		ids[i] = player.ID
+	}
+
+	return TicketInfo{
+		ID:        group.ID,
+		PlayerIDs: ids,
+		AvgRating: group.AvgRating,
+		Pool:      group.pool,
+		QueuedAt:  group.queuedAt,
+		Requeued:  group.requeued,
+	}
+}
+
+// Match waiting for players to accept it
+type readyCheck struct {
+	teams    []Team
+	deadline time.Time
+}
+
+func (c *readyCheck) info() ReadyCheckInfo {
+	info := ReadyCheckInfo{
+		PlayerIDs:      make([]int, 0),
+		ReadyPlayerIDs: make([]int, 0),
+		Deadline:       c.deadline,
+	}
+	for _, team := range c.teams {
+		for _, group := range team.groups {
+			for _, player := range group.Players {
+				info.PlayerIDs = append(info.PlayerIDs, player.ID)
+				if player.ready {
+					info.ReadyPlayerIDs = append(info.ReadyPlayerIDs, player.ID)
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+func (m *matchmaker) State() QueueState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+func (m *matchmaker) Pause() {
+	m.setState(QueuePaused)
+}
+
+func (m *matchmaker) Resume() {
+	m.setState(QueueRunning)
+}
+
+func (m *matchmaker) Drain() {
+	m.setState(QueueDraining)
+}
+
+func (m *matchmaker) SetOwned(owned bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.notOwned == !owned {
+		return
+	}
+	m.notOwned = !owned
+	if owned || m.stopped {
+		m.logger.Info("queue ownership changed", "owned", owned)
+		return
+	}
+
+	// Matches in ready check or server allocation are finished, they already left search
+	ids := make([]string, 0, m.searchQueue.Len())
+	for m.searchQueue.Len() > 0 {
+		group := m.searchQueue.Front().Value.(*Group)
+		cancelGroup(group, CancelledOwnerChanged)
+		m.removeGroupFromSearch(group)
+		delete(m.groupIDs, group.ID)
+		ids = append(ids, group.ID)
+	}
+	if len(ids) > 0 {
+		m.journal(m.store.TicketsRemoved(ids, RemovedCancelled))
+	}
+	m.logger.Info("queue ownership changed", "owned", owned, "cancelled_groups", len(ids))
+}
+
+func (m *matchmaker) setState(state QueueState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Queue which is shut down doesn't take groups anymore
+	if !m.stopped {
+		m.state = state
+	}
+}
+
+// Makes one matchmaking pass, also when the queue is paused, but not when another instance owns it
+func (m *matchmaker) RunPass() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.stopped && !m.notOwned {
+		m.makeMatch()
+	}
+}
@@ -0,0 +1,130 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueStats(t *testing.T) {
+	params := &config.MatchmakerConfig{TeamSize: 5, TeamCount: 2, PenaltySeconds: 60}
+	mm := newTestMatchmaker(params, nil, nil)
+	mm.penalizedPlayers = map[int]time.Time{
+		10: time.Now(),
+		11: time.Now().Add(-2 * time.Minute),
+	}
+
+	now := time.Now()
+	groups := []*Group{
+		{ID: "1", Players: []Player{{ID: 1}}, Size: 1, AvgRating: 150, queuedAt: now.Add(-time.Minute)},
+		{ID: "2", Players: []Player{{ID: 2}, {ID: 3}}, Size: 2, AvgRating: 120, queuedAt: now.Add(-3 * time.Minute)},
+		{ID: "3", Players: []Player{{ID: 4}}, Size: 1, AvgRating: 230, queuedAt: now.Add(-2 * time.Minute)},
+	}
+	for _, group := range groups {
+		mm.returnGroupToSearch(group)
+	}
+
+	check := &readyCheck{teams: []Team{{groups: []*Group{{Players: []Player{{ID: 5, ready: true}, {ID: 6}}}}}}}
+	mm.readyChecks[check] = true
+
+	stats := mm.Stats(100, 2)
+	if stats.Depth != 3 || stats.State != QueueRunning {
+		t.Errorf("got depth %d in state %s, want 3 in %s", stats.Depth, stats.State, QueueRunning)
+	}
+	if stats.BySize[1] != 2 || stats.BySize[2] != 1 {
+		t.Errorf("got sizes %v, want 2 solo groups and 1 pair", stats.BySize)
+	}
+	want := []RatingBucket{{Min: 100, Max: 199, Count: 2}, {Min: 200, Max: 299, Count: 1}}
+	if len(stats.ByRating) != len(want) || stats.ByRating[0] != want[0] || stats.ByRating[1] != want[1] {
+		t.Errorf("got buckets %v, want %v", stats.ByRating, want)
+	}
+	if len(stats.Oldest) != 2 || stats.Oldest[0].ID != "2" || stats.Oldest[1].ID != "3" {
+		t.Errorf("got oldest %v, want groups 2 and 3", stats.Oldest)
+	}
+	if len(stats.ReadyChecks) != 1 || len(stats.ReadyChecks[0].PlayerIDs) != 2 || len(stats.ReadyChecks[0].ReadyPlayerIDs) != 1 {
+		t.Errorf("got ready checks %v, want one with 1 of 2 players ready", stats.ReadyChecks)
+	}
+	if len(stats.Penalized) != 1 || stats.Penalized[0].ID != 10 {
+		t.Errorf("got penalized %v, want only player 10", stats.Penalized)
+	}
+}
+
+func TestQueueControl(t *testing.T) {
+	params := &config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100}
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(params, &repositorytest.Repository{}, matches)
+
+	mm.Pause()
+	addSoloGroups(t, mm, 1, 2)
+	if mm.State() != QueuePaused {
+		t.Errorf("got state %s, want %s", mm.State(), QueuePaused)
+	}
+
+	mm.RunPass()
+	select {
+	case <-matches:
+	case <-time.After(time.Second):
+		t.Fatal("manual pass didn't make a match in paused queue")
+	}
+
+	mm.Drain()
+	err := mm.AddGroup(context.Background(), "3", []int{3}, nil, make(chan string, 1), make(chan CancelReason, 1))
+	if !errors.Is(err, ErrQueueDraining) {
+		t.Errorf("got %v, want %v", err, ErrQueueDraining)
+	}
+
+	mm.Resume()
+	mm.returnGroupToSearch(&Group{ID: "4", Players: []Player{{ID: 4}}, Size: 1, cancelSearch: make(chan CancelReason, 1)})
+	if !mm.RemoveGroup("4") {
+		t.Errorf("group 4 is not removed from search")
+	}
+	if mm.RemoveGroup("4") {
+		t.Errorf("removed group 4 twice")
+	}
+}
+
+func TestQueueOwnership(t *testing.T) {
+	params := &config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100}
+	repo := &repositorytest.Repository{DefaultRating: 100}
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(params, repo, matches)
+
+	mm.Pause()
+	cancelled := make(chan CancelReason, 1)
+	if err := mm.AddGroup(context.Background(), "1", []int{1}, nil, make(chan string, 1), cancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lost queue is not matched anymore, the lobby queues its groups with the new owner
+	mm.SetOwned(false)
+	select {
+	case reason := <-cancelled:
+		if reason != CancelledOwnerChanged {
+			t.Errorf("got reason %s, want %s", reason, CancelledOwnerChanged)
+		}
+	default:
+		t.Error("group is not cancelled when the queue is lost")
+	}
+	err := mm.AddGroup(context.Background(), "2", []int{2}, nil, make(chan string, 1), make(chan CancelReason, 1))
+	if !errors.Is(err, ErrQueueNotOwned) {
+		t.Errorf("got %v, want %v", err, ErrQueueNotOwned)
+	}
+	mm.returnGroupToSearch(&Group{ID: "3", Players: []Player{{ID: 3}}, Size: 1, AvgRating: 100})
+	mm.returnGroupToSearch(&Group{ID: "4", Players: []Player{{ID: 4}}, Size: 1, AvgRating: 100})
+	mm.RunPass()
+	select {
+	case <-matches:
+		t.Error("match is made in queue owned by another instance")
+	default:
+	}
+
+	// Ownership doesn't override the state set by admin
+	mm.SetOwned(true)
+	if stats := mm.Stats(100, 0); stats.State != QueuePaused || !stats.Owned {
+		t.Errorf("got state %s, owned %v, want %s and owned", stats.State, stats.Owned, QueuePaused)
+	}
+}
@@ -0,0 +1,70 @@
+package matchmaker
+
+import (
+	"goplay/config"
+
+	"fmt"
+)
+
+// Returned when a group is not admitted to search, Rule is the name of violated rule
+type AdmissionError struct {
+	Rule string
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("group violates admission rule %q", e.Rule)
+}
+
+func (m *matchmaker) checkAdmissionRules(group *Group) error {
+	for _, rule := range m.params.AdmissionRules {
+		allowed, err := admits(rule, group)
+		if err != nil {
+			return err
+		}
+
+		if !allowed {
+			name := rule.Name
+			if name == "" {
+				name = rule.Type
+			}
+			return &AdmissionError{Rule: name}
+		}
+	}
+
+	return nil
+}
+
+func admits(rule config.AdmissionRuleConfig, group *Group) (bool, error) {
+	switch rule.Type {
+	case config.AdmissionMaxRatingSpread:
+		return group.ratingSpread() <= rule.Value, nil
+	case config.AdmissionMaxPartyRating:
+		if len(group.Players) < 2 {
+			return true, nil
+		}
+		for _, player := range group.Players {
+			if player.Rating > rule.Value {
+				return false, nil
+			}
+		}
+	case config.AdmissionMinAccountLevel:
+		for _, player := range group.Players {
+			if player.level < rule.Value {
+				return false, nil
+			}
+		}
+	case config.AdmissionTopRankPartySize:
+		if len(group.Players) <= rule.Value {
+			return true, nil
+		}
+		for _, player := range group.Players {
+			if player.rank > 0 && player.rank <= rule.TopRank {
+				return false, nil
+			}
+		}
+	default:
+		return false, fmt.Errorf("unknown admission rule type %q", rule.Type)
+	}
+
+	return true, nil
+}
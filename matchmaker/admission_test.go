@@ -0,0 +1,76 @@
+package matchmaker
+
+import (
+	"goplay/config"
+
+	"errors"
+	"testing"
+)
+
+func TestRatingSpreadInGroup(t *testing.T) {
+	mm := &matchmaker{
+		params: &config.MatchmakerConfig{MaxRatingSpreadInGroup: 100},
+	}
+
+	group := &Group{Players: []Player{{Rating: 1500}, {Rating: 1550}}}
+	if err := mm.checkRatingSpread(group); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	group = &Group{Players: []Player{{Rating: 1500}, {Rating: 1650}}}
+	if err := mm.checkRatingSpread(group); err == nil {
+		t.Errorf("got nil, want error")
+	}
+}
+
+func TestAdmissionRules(t *testing.T) {
+	mm := &matchmaker{
+		params: &config.MatchmakerConfig{
+			AdmissionRules: []config.AdmissionRuleConfig{
+				{Name: "spread", Type: "maxRatingSpread", Value: 200},
+				{Name: "rankedParty", Type: "maxPartyRating", Value: 2500},
+				{Name: "level", Type: "minAccountLevel", Value: 30},
+				{Name: "top100", Type: "topRankPartySize", Value: 2, TopRank: 100},
+			},
+		},
+	}
+
+	tests := []struct {
+		players []Player
+		rule    string
+	}{
+		{[]Player{{Rating: 1000, level: 30}, {Rating: 1100, level: 40}}, ""},
+		{[]Player{{Rating: 1000, level: 30}, {Rating: 1300, level: 40}}, "spread"},
+		{[]Player{{Rating: 2600, level: 30}, {Rating: 2500, level: 40}}, "rankedParty"},
+		{[]Player{{Rating: 2600, level: 30}}, ""},
+		{[]Player{{Rating: 1000, level: 29}}, "level"},
+		{[]Player{{Rating: 2400, level: 30, rank: 50}, {Rating: 2400, level: 30}}, ""},
+		{[]Player{{Rating: 2400, level: 30, rank: 50}, {Rating: 2400, level: 30}, {Rating: 2400, level: 30}}, "top100"},
+		{[]Player{{Rating: 2400, level: 30, rank: 150}, {Rating: 2400, level: 30}, {Rating: 2400, level: 30}}, ""},
+	}
+
+	for i, test := range tests {
+		err := mm.checkAdmissionRules(&Group{Players: test.players})
+		var admissionErr *AdmissionError
+		switch {
+		case test.rule == "" && err != nil:
+			t.Errorf("case %d: got %s, want nil", i, err)
+		case test.rule != "" && !errors.As(err, &admissionErr):
+			t.Errorf("case %d: got %v, want violated rule %s", i, err, test.rule)
+		case test.rule != "" && admissionErr.Rule != test.rule:
+			t.Errorf("case %d: got %s, want %s", i, admissionErr.Rule, test.rule)
+		}
+	}
+}
+
+func TestUnknownAdmissionRule(t *testing.T) {
+	mm := &matchmaker{
+		params: &config.MatchmakerConfig{
+			AdmissionRules: []config.AdmissionRuleConfig{{Type: "unknown"}},
+		},
+	}
+
+	if err := mm.checkAdmissionRules(&Group{Players: []Player{{}}}); err == nil {
+		t.Errorf("got nil, want error")
+	}
+}
@@ -0,0 +1,123 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+
+	"context"
+	"time"
+)
+
+func (m *matchmaker) avoidRulesEnabled() bool {
+	return avoidRulesEnabled(m.params)
+}
+
+func avoidRulesEnabled(params *config.MatchmakerConfig) bool {
+	return params.BlockScope != "" || avoidRematch(params)
+}
+
+// Rematches are avoided only in 1v1 queues, in team queues recent opponents are too common to skip
+func avoidRematch(params *config.MatchmakerConfig) bool {
+	if params.MinutesToAvoidRematch <= 0 {
+		return false
+	}
+
+	slots := params.TeamSlots()
+	return len(slots) == 2 && slots[0].Size == 1 && slots[1].Size == 1
+}
+
+// Loads players blocked by the group and opponents they recently played with
+func loadAvoidLists(ctx context.Context, repo repository.Repository, params *config.MatchmakerConfig, group *Group) error {
+	if !avoidRulesEnabled(params) {
+		return nil
+	}
+
+	ids := make([]int, len(group.Players))
+	for i, player := range group.Players {
+		ids[i] = player.ID
+	}
+
+	var blocked, recentOpponents map[int][]int
+	var err error
+	if params.BlockScope != "" {
+		blocked, err = repo.GetBlockLists(ctx, ids)
+		if err != nil {
+			return err
+		}
+	}
+	if avoidRematch(params) {
+		since := time.Now().Add(-time.Duration(params.MinutesToAvoidRematch) * time.Minute)
+		recentOpponents, err = repo.GetRecentOpponents(ctx, ids, since)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range group.Players {
+		group.Players[i].blocked = toSet(blocked[group.Players[i].ID])
+		group.Players[i].recentOpponents = toSet(recentOpponents[group.Players[i].ID])
+	}
+
+	return nil
+}
+
+func toSet(ids []int) map[int]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+// Checks if group can't be added to the team because of block lists or recent matches
+func (m *matchmaker) conflicts(teams []Team, team *Team, group *Group) bool {
+	if !m.avoidRulesEnabled() {
+		return false
+	}
+
+	for i := range teams {
+		sameTeam := &teams[i] == team
+		for _, other := range teams[i].groups {
+			if groupsEqual(other, group) {
+				continue
+			}
+			if (sameTeam || m.params.BlockScope == config.BlockScopeMatch) && groupsBlocked(group, other) {
+				return true
+			}
+			if !sameTeam && avoidRematch(m.params) && groupsPlayedRecently(group, other) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func groupsBlocked(a, b *Group) bool {
+	for _, p := range a.Players {
+		for _, q := range b.Players {
+			if p.blocked[q.ID] || q.blocked[p.ID] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func groupsPlayedRecently(a, b *Group) bool {
+	for _, p := range a.Players {
+		for _, q := range b.Players {
+			if p.recentOpponents[q.ID] || q.recentOpponents[p.ID] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
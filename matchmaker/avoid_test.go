@@ -0,0 +1,114 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func addSoloGroups(t *testing.T, mm *matchmaker, ids ...int) {
+	for i, id := range ids {
+		group := &Group{
+			ID:         strconv.Itoa(id),
+			Players:    []Player{{ID: id, Rating: 100 + i}},
+			Size:       1,
+			AvgRating:  100 + i,
+			matchFound: make(chan string, 1),
+		}
+		if err := loadAvoidLists(context.Background(), mm.repository, mm.params, group); err != nil {
+			t.Fatal(err)
+		}
+		mm.returnGroupToSearch(group)
+	}
+}
+
+func TestAvoidRematch(t *testing.T) {
+	params := &config.MatchmakerConfig{
+		TeamSize:                1,
+		TeamCount:               2,
+		MaxRatingSpreadToSearch: 100,
+		MinutesToAvoidRematch:   30,
+	}
+	repo := &repositorytest.Repository{RecentOpponents: map[int][]int{1: {2}}}
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(params, repo, matches)
+	addSoloGroups(t, mm, 1, 2, 3)
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		if opponent := match.Teams[1].groups[0].Players[0].ID; opponent != 3 {
+			t.Errorf("got opponent %d, want %d", opponent, 3)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestAvoidRematchOnlyInSoloQueues(t *testing.T) {
+	params := &config.MatchmakerConfig{
+		TeamSize:                2,
+		TeamCount:               2,
+		MaxRatingSpreadToSearch: 100,
+		MinutesToAvoidRematch:   30,
+	}
+	repo := &repositorytest.Repository{RecentOpponents: map[int][]int{1: {2, 3, 4}}}
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(params, repo, matches)
+	addSoloGroups(t, mm, 1, 2, 3, 4)
+
+	mm.makeMatch()
+
+	select {
+	case <-matches:
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []struct {
+		scope     string
+		wantMatch bool
+	}{
+		{config.BlockScopeTeam, true},
+		{config.BlockScopeMatch, false},
+	}
+
+	for _, test := range tests {
+		params := &config.MatchmakerConfig{
+			TeamSize:                2,
+			TeamCount:               2,
+			MaxRatingSpreadToSearch: 100,
+			BlockScope:              test.scope,
+		}
+		// Player 1 blocked player 2, so they can only be opponents
+		repo := &repositorytest.Repository{Blocked: map[int][]int{1: {2}}}
+		matches := make(chan *Match, 1)
+		mm := newTestMatchmaker(params, repo, matches)
+		addSoloGroups(t, mm, 1, 2, 3, 4)
+
+		mm.makeMatch()
+
+		select {
+		case match := <-matches:
+			if !test.wantMatch {
+				t.Fatalf("scope %s: match created with blocked players", test.scope)
+			}
+			for _, team := range match.Teams {
+				if groupsBlocked(team.groups[0], team.groups[1]) {
+					t.Errorf("scope %s: blocked players in the same team", test.scope)
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+			if test.wantMatch {
+				t.Fatalf("scope %s: match was not created", test.scope)
+			}
+		}
+	}
+}
@@ -1,11 +1,32 @@
 package matchmaker
 
+import (
+	"goplay/config"
+
+	"context"
+	"sort"
+	"time"
+)
+
 type Player struct {
-	ID           int `json:"id"`
-	Rating       int `json:"rating"`
-	ping         int
-	wonLastMatch bool
-	ready        bool
+	ID     int    `json:"id"`
+	Rating int    `json:"rating"`
+	Role   string `json:"role,omitempty"`
+	// Preferred roles, most wanted first
+	roles           []string
+	level           int
+	rank            int
+	priority        int
+	blocked         map[int]bool
+	recentOpponents map[int]bool
+	flags           []string
+	ping            int
+	wonLastMatch    bool
+	// Wins in a row if positive, losses if negative
+	streak           int
+	ratingAdjustment int
+	ready            bool
+	declined         bool
 }
 
 // Base struct for matchmaker, may consist of one player
@@ -16,23 +37,90 @@ type Group struct {
 	AvgRating        int
 	SumRating        int
 	SelectedForMatch bool
-	matchFound       chan string
-	cancelSearch     chan bool
+	queuedAt         time.Time
+	priority         int
+	pool             string
+	requeued         bool
+	lastSeededAt     time.Time
+	// Sum of priority sources when the group entered search
+	seedPriority float64
+	// Positions of the group in seed order heaps
+	seedIndex    int
+	waitIndex    int
+	matchFound   chan string
+	cancelSearch chan CancelReason
+	traceCtx     context.Context
 }
 
 type Team struct {
-	groups     []*Group
-	numPlayers int
+	groups      []*Group
+	numPlayers  int
+	size        int
+	minSize     int
+	composition map[string]int
+	// Role which every player must opt in to
+	requiredRole string
+	roles        map[string]int
+}
+
+func newTeams(params *config.MatchmakerConfig) []Team {
+	slots := params.TeamSlots()
+	teams := make([]Team, len(slots))
+	for i, slot := range slots {
+		teams[i].size = slot.Size
+		teams[i].minSize = slot.MinSize
+		if slot.MinSize <= 0 {
+			teams[i].minSize = slot.Size
+		}
+		teams[i].composition = params.Roles
+		if slot.Role != "" {
+			teams[i].composition = nil
+			teams[i].requiredRole = slot.Role
+		}
+	}
+
+	return teams
 }
 
 func (g *Group) calcRating() {
 	for i := range g.Players {
-		g.SumRating += g.Players[i].Rating
+		g.SumRating += g.Players[i].matchmakingRating()
 	}
 
 	g.AvgRating = g.SumRating / len(g.Players)
 }
 
+// Rating used to search for players of similar skill
+func (p *Player) matchmakingRating() int {
+	return p.Rating + p.ratingAdjustment
+}
+
+func (g *Group) calcPriority() {
+	for i := range g.Players {
+		if g.Players[i].priority > g.priority {
+			g.priority = g.Players[i].priority
+		}
+	}
+}
+
+func (g *Group) ratingSpread() int {
+	if len(g.Players) == 0 {
+		return 0
+	}
+
+	min, max := g.Players[0].Rating, g.Players[0].Rating
+	for _, player := range g.Players[1:] {
+		if player.Rating < min {
+			min = player.Rating
+		}
+		if player.Rating > max {
+			max = player.Rating
+		}
+	}
+
+	return max - min
+}
+
 func groupsEqual(a, b *Group) bool {
 	return a.ID == b.ID
 }
@@ -40,6 +128,7 @@ func groupsEqual(a, b *Group) bool {
 func (t *Team) add(group *Group) {
 	t.groups = append(t.groups, group)
 	t.numPlayers += group.Size
+	t.countRoles(group, 1)
 }
 
 func (t *Team) remove(group *Group) {
@@ -53,18 +142,151 @@ func (t *Team) remove(group *Group) {
 	if index >= 0 {
 		t.groups = append(t.groups[:index], t.groups[index+1:]...)
 		t.numPlayers -= group.Size
+		t.countRoles(group, -1)
+	}
+}
+
+func (t *Team) has(group *Group) bool {
+	for _, g := range t.groups {
+		if groupsEqual(g, group) {
+			return true
+		}
 	}
+
+	return false
+}
+
+func (t *Team) countRoles(group *Group, delta int) {
+	for _, player := range group.Players {
+		if player.Role == "" {
+			continue
+		}
+		if t.roles == nil {
+			t.roles = make(map[string]int)
+		}
+		t.roles[player.Role] += delta
+	}
+}
+
+// Checks if group can join the team, its players keep their roles
+func (t *Team) canJoin(group *Group, depth int) bool {
+	_, ok := t.rolesFor(group, depth)
+	return ok
+}
+
+// Roles which players of the group take if it joins the team, so that team still fits the composition.
+// Only first depth preferences of each player are considered, depth <= 0 means all of them.
+// Roles are assigned to a copy of the players, groups probed for the team are left as they are.
+func (t *Team) rolesFor(group *Group, depth int) ([]string, bool) {
+	if group.Size > t.size-t.numPlayers {
+		return nil, false
+	}
+
+	players := append([]Player(nil), group.Players...)
+	switch {
+	case t.requiredRole != "":
+		if !assignRequiredRole(players, t.requiredRole) {
+			return nil, false
+		}
+	case len(t.composition) == 0:
+		for i := range players {
+			players[i].Role = ""
+		}
+	default:
+		taken := make(map[string]int, len(t.roles))
+		for role, count := range t.roles {
+			taken[role] = count
+		}
+		if !assignPlayerRoles(players, t.composition, taken, depth) {
+			return nil, false
+		}
+	}
+
+	roles := make([]string, len(players))
+	for i := range players {
+		roles[i] = players[i].Role
+	}
+	return roles, true
+}
+
+// Adds the group to the team if it can join it, its players take roles in the team
+func (t *Team) join(group *Group, depth int) bool {
+	roles, ok := t.rolesFor(group, depth)
+	if !ok {
+		return false
+	}
+
+	for i := range group.Players {
+		group.Players[i].Role = roles[i]
+	}
+	t.add(group)
+	return true
+}
+
+func assignRequiredRole(players []Player, role string) bool {
+	for _, player := range players {
+		optedIn := false
+		for _, preferred := range player.roles {
+			if preferred == role {
+				optedIn = true
+			}
+		}
+		if !optedIn {
+			return false
+		}
+	}
+
+	for i := range players {
+		players[i].Role = role
+	}
+
+	return true
+}
+
+func assignPlayerRoles(players []Player, composition, taken map[string]int, depth int) bool {
+	if len(players) == 0 {
+		return true
+	}
+
+	// Player without preferences can take any role
+	preferred := players[0].roles
+	if len(preferred) == 0 {
+		for role := range composition {
+			preferred = append(preferred, role)
+		}
+		sort.Strings(preferred)
+	} else if depth > 0 && len(preferred) > depth {
+		preferred = preferred[:depth]
+	}
+
+	for _, role := range preferred {
+		if taken[role] >= composition[role] {
+			continue
+		}
+
+		taken[role]++
+		players[0].Role = role
+		if assignPlayerRoles(players[1:], composition, taken, depth) {
+			return true
+		}
+		taken[role]--
+	}
+
+	players[0].Role = ""
+	return false
 }
 
-func (t *Team) fill(m *matchmaker, avgRating int) error {
-	for t.numPlayers < m.params.TeamSize {
-		playersToAdd := m.params.TeamSize - t.numPlayers
-		g, err := m.findGroupWithSameRating(avgRating, playersToAdd)
+// Adds groups with rating of the seed until the team has at least size players
+func (t *Team) fill(m *matchmaker, seed *Group, size int) error {
+	for t.numPlayers < size {
+		g, err := m.findGroupWithSameRating(seed.AvgRating, t.size-t.numPlayers, func(g *Group) bool {
+			return g.pool == seed.pool && t.canJoin(g, m.roleFallbackDepth(g)) && !m.conflicts(m.preparingMatchTeams, t, g)
+		})
 		if err != nil {
 			return err
 		}
 
-		t.add(g)
+		t.join(g, m.roleFallbackDepth(g))
 	}
 	return nil
 }
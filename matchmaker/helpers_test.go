@@ -0,0 +1,19 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+
+	"context"
+)
+
+// Matchmaker is built like in production, so its fields get defaults. Created matches are sent to the channel if it's set.
+func newTestMatchmaker(params *config.MatchmakerConfig, repo repository.Repository, matches chan *Match, opts ...Option) *matchmaker {
+	cfg := &config.Config{Matchmaker: *params}
+	return NewMatchmaker(repo, cfg, func(ctx context.Context, match *Match, sendTo string) (string, error) {
+		if matches != nil {
+			matches <- match
+		}
+		return "", nil
+	}, opts...).(*matchmaker)
+}
@@ -9,74 +9,344 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrGroupExists     = errors.New("group with the same ID is already in search")
+	ErrInvalidGroup    = errors.New("invalid group")
+	ErrPlayersNotFound = errors.New("players are not found")
 )
 
 type matchmaker struct {
+	// Guards state of search and params, which can be replaced at any time
+	mu                  sync.Mutex
 	repository          repository.Repository
 	searchQueue         *list.List
-	rankedTable         RankedGroupsTable
+	rankedTable         *RankedGroupsTable
 	preparingMatchTeams []Team
+	// Groups probed or selected by the current pass, each of them gets a span of the pass
+	consideredGroups    map[*Group]bool
 	waitingMatchPlayers map[int]*Player
 	penalizedPlayers    map[int]time.Time
-	params              *config.MatchmakerConfig
-	serverConfig        *config.ServerConfig
-	matchReadyCallback  func(teams []Team, sendTo string) string
+	// Groups in search or in matches in progress, so the same group can't be added twice
+	groupIDs           map[string]bool
+	params             *config.MatchmakerConfig
+	serverConfig       *config.ServerConfig
+	matchReadyCallback func(ctx context.Context, match *Match, sendTo string) (string, error)
+	prioritySources    []PrioritySource
+	customPriority     bool
+	// Groups in search by priority and by time they were not seeded for
+	seeds    groupHeap
+	unseeded groupHeap
+	// Groups which seeded since the last group entered search
+	parkedSeeds map[*Group]bool
+	flagger     PlayerFlagger
+	// Set by admin API, it's kept when ownership of the queue changes
+	state QueueState
+	// Set while another instance of the cluster owns the queue, no groups are taken and no matches are made
+	notOwned    bool
+	readyChecks map[*readyCheck]bool
+	metrics     Metrics
+	logger      *slog.Logger
+	store       StateStore
+	listener    TicketListener
+	// Set by shutdown, no more matches are made
+	stopped bool
+	// Matches in ready check or server allocation
+	inFlight sync.WaitGroup
+}
+
+type Option func(m *matchmaker)
+
+// Replaces default logger, e.g. to add name of the queue to records
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *matchmaker) {
+		m.logger = logger
+	}
+}
+
+// Replaces default flagger based on thresholds from config
+func WithPlayerFlagger(flagger PlayerFlagger) Option {
+	return func(m *matchmaker) {
+		m.flagger = flagger
+	}
+}
+
+// Replaces default priority sources used to choose seed of the next match
+func WithPrioritySources(sources ...PrioritySource) Option {
+	return func(m *matchmaker) {
+		m.prioritySources = sources
+		m.customPriority = true
+	}
 }
 
+const (
+	readyCheckInterval = 100 * time.Millisecond
+	// Delay between passes which made no match
+	idleInterval = 10 * time.Millisecond
+)
+
+// Why search of the group is cancelled
+type CancelReason string
+
+const (
+	CancelledByRequest CancelReason = "request"
+	// Some player of the group didn't accept the match
+	CancelledNotReady CancelReason = "not_ready"
+	// Matchmaker is shutting down and the group is dropped
+	CancelledForMaintenance CancelReason = "maintenance"
+	// Matchmaker is shutting down, the group is kept in the state store and returns to search after restart
+	SuspendedForRestart CancelReason = "restart"
+	// Another instance of the cluster took the queue, the group may be queued again there
+	CancelledOwnerChanged CancelReason = "owner_changed"
+)
+
 type Matchmaker interface {
-	AddGroup(ctx context.Context, id string, playerIDs []int, matchFound chan string, searchCancelled chan bool) error
-	RemoveGroup(id string)
+	AddGroup(ctx context.Context, id string, playerIDs []int, roles map[int][]string, matchFound chan string, searchCancelled chan CancelReason) error
+	// Cancels search of the group, returns false if it's not in search
+	RemoveGroup(id string) bool
 	SetPlayerReady(id int)
+	// Player refuses the match, its ready check fails at once
+	SetPlayerDeclined(id int)
+	// Applies new params to the running matchmaker, groups in search are kept
+	UpdateParams(params *config.MatchmakerConfig)
 	Run()
+	Stats(bucketWidth, oldest int) QueueStats
+	State() QueueState
+	Pause()
+	Resume()
+	Drain()
+	// Queue owned by another instance takes no groups and makes no matches, its groups in search are cancelled
+	SetOwned(owned bool)
+	RunPass()
+	// Restores queue from the state store, must be called before Run
+	Restore(ctx context.Context) error
+	// Stops matchmaking, waits for matches in progress and cancels groups left in search
+	Shutdown(ctx context.Context) error
 }
 
-func NewMatchmaker(repository repository.Repository, cfg *config.Config, onMatchReady func(teams []Team, sendTo string) string) Matchmaker {
-	return &matchmaker{
-		repository:         repository,
-		searchQueue:        list.New(),
-		rankedTable:        make(RankedGroupsTable),
-		params:             &cfg.Matchmaker,
-		serverConfig:       &cfg.Server,
-		matchReadyCallback: onMatchReady,
+func NewMatchmaker(repository repository.Repository, cfg *config.Config, onMatchReady func(ctx context.Context, match *Match, sendTo string) (string, error), opts ...Option) Matchmaker {
+	m := &matchmaker{
+		repository:          repository,
+		searchQueue:         list.New(),
+		rankedTable:         NewRankedGroupsTable(),
+		unseeded:            groupHeap{byWait: true},
+		waitingMatchPlayers: make(map[int]*Player),
+		penalizedPlayers:    make(map[int]time.Time),
+		groupIDs:            make(map[string]bool),
+		params:              &cfg.Matchmaker,
+		serverConfig:        &cfg.Server,
+		matchReadyCallback:  onMatchReady,
+		prioritySources:     defaultPrioritySources(&cfg.Matchmaker),
+		state:               QueueRunning,
+		readyChecks:         make(map[*readyCheck]bool),
+		metrics:             noopMetrics{},
+		logger:              slog.Default(),
+		store:               noopStore{},
+		listener:            noopListener{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+func defaultPrioritySources(params *config.MatchmakerConfig) []PrioritySource {
+	return []PrioritySource{
+		RequeuePriority{Boost: float64(params.RequeuePriorityBoost)},
+		// Each level of ticket priority is worth a requeue boost of 60
+		TicketPriority{PointsPerLevel: 60},
 	}
 }
 
 func (m *matchmaker) Run() {
 	for {
-		m.makeMatch()
+		m.mu.Lock()
+		if m.stopped {
+			m.mu.Unlock()
+			return
+		}
+		depth := m.searchQueue.Len()
+		if m.state != QueuePaused && !m.notOwned {
+			m.makeMatch()
+		}
+		// Groups leave the queue only when the pass makes a match
+		idle := m.searchQueue.Len() == depth
+		m.metrics.QueueDepth(m.searchQueue.Len())
+		m.mu.Unlock()
+
+		if idle {
+			time.Sleep(idleInterval)
+		}
 	}
 }
 
-func (m *matchmaker) AddGroup(ctx context.Context, id string, playerIDs []int, matchFound chan string, searchCancelled chan bool) error {
-	context, cancel := context.WithTimeout(ctx, m.serverConfig.DBRequestTimeout)
+func (m *matchmaker) UpdateParams(params *config.MatchmakerConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.params = params
+	if !m.customPriority {
+		m.prioritySources = defaultPrioritySources(params)
+		m.updateSeedPriorities()
+	}
+	m.logger.Info("matchmaker params updated", "groups", m.searchQueue.Len())
+}
+
+func (m *matchmaker) AddGroup(ctx context.Context, id string, playerIDs []int, roles map[int][]string, matchFound chan string, searchCancelled chan CancelReason) (err error) {
+	// Spans of the ticket are children of the request span, but not cancelled with the request
+	traceCtx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	ctx, span := tracer().Start(ctx, "matchmaker.AddGroup", trace.WithAttributes(attribute.String("group.id", id)))
+	defer func() {
+		endSpan(span, err)
+		if err != nil {
+			m.logger.InfoContext(ctx, "group is not added to search", "group_id", id, "player_ids", playerIDs, "error", err)
+		}
+	}()
+
+	m.mu.Lock()
+	draining := m.state == QueueDraining
+	notOwned := m.notOwned
+	exists := m.groupIDs[id]
+	m.mu.Unlock()
+	if draining {
+		return ErrQueueDraining
+	}
+	if notOwned {
+		return ErrQueueNotOwned
+	}
+	if exists {
+		return ErrGroupExists
+	}
+
+	group, playersInfo, err := m.newGroup(ctx, id, playerIDs, roles)
+	if err != nil {
+		return err
+	}
+	group.matchFound = matchFound
+	group.cancelSearch = searchCancelled
+	group.traceCtx = traceCtx
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state == QueueDraining {
+		return ErrQueueDraining
+	}
+	if m.notOwned {
+		return ErrQueueNotOwned
+	}
+
+	err = m.enqueue(group, playersInfo)
+	if err != nil {
+		return err
+	}
+	m.logger.DebugContext(ctx, "group added to search", "group_id", id, "player_ids", playerIDs,
+		"avg_rating", group.AvgRating, "pool", group.pool)
+
+	return nil
+}
+
+// Builds group from player info and other data from repository
+func (m *matchmaker) newGroup(ctx context.Context, id string, playerIDs []int, roles map[int][]string) (*Group, []repository.PlayerInfo, error) {
+	if len(playerIDs) == 0 {
+		return nil, nil, fmt.Errorf("%w: group has no players", ErrInvalidGroup)
+	}
+	listed := make(map[int]bool, len(playerIDs))
+	for _, playerID := range playerIDs {
+		if listed[playerID] {
+			return nil, nil, fmt.Errorf("%w: player %d is listed twice", ErrInvalidGroup, playerID)
+		}
+		listed[playerID] = true
+	}
+
+	context, cancel := context.WithTimeout(ctx, m.serverConfig.DBRequestTimeout.Duration)
 	defer cancel()
 
+	// Repository lookups are made without the lock, so they use params read beforehand
+	m.mu.Lock()
+	params := m.params
+	m.mu.Unlock()
+
 	playersInfo, err := m.repository.GetUsersById(context, playerIDs)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	// Rating of the group is averaged over players, so all of them must be known
+	if len(playersInfo) != len(playerIDs) {
+		for _, info := range playersInfo {
+			delete(listed, int(info.ID))
+		}
+		missing := make([]int, 0, len(listed))
+		for playerID := range listed {
+			missing = append(missing, playerID)
+		}
+		sort.Ints(missing)
+		return nil, nil, fmt.Errorf("%w: %v", ErrPlayersNotFound, missing)
 	}
 
 	players := make([]Player, len(playersInfo))
 	for i := range players {
 		players[i] = Player{
-			ID:     int(playersInfo[i].ID),
-			Rating: playersInfo[i].Rating,
+			ID:       int(playersInfo[i].ID),
+			Rating:   playersInfo[i].Rating,
+			level:    playersInfo[i].Level,
+			rank:     playersInfo[i].Rank,
+			priority: playersInfo[i].Priority,
+			roles:    roles[int(playersInfo[i].ID)],
 		}
 	}
 
 	group := &Group{
-		ID:           id,
-		Players:      players,
-		Size:         len(players),
-		matchFound:   matchFound,
-		cancelSearch: searchCancelled,
+		ID:       id,
+		Players:  players,
+		Size:     len(players),
+		queuedAt: time.Now(),
+	}
+
+	err = loadAvoidLists(context, m.repository, params, group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = applyStreakCompensation(context, m.repository, params, group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return group, playersInfo, nil
+}
+
+// Checks group and adds it to search, must be called under lock
+func (m *matchmaker) enqueue(group *Group, playersInfo []repository.PlayerInfo) error {
+	// Checked again, the same group may be added while players are loaded
+	if m.groupIDs[group.ID] {
+		return ErrGroupExists
+	}
+	for i := range group.Players {
+		group.Players[i].flags = m.playerFlags(playersInfo[i])
 	}
+	group.pool = m.routeGroup(group)
 
-	err = m.checkRatingSpread(group)
+	err := m.checkRatingSpread(group)
+	if err != nil {
+		return err
+	}
+
+	err = m.checkAdmissionRules(group)
 	if err != nil {
 		return err
 	}
@@ -87,14 +357,27 @@ func (m *matchmaker) AddGroup(ctx context.Context, id string, playerIDs []int, m
 	}
 
 	group.calcRating()
+	group.calcPriority()
+
+	// Group which is not journaled would be lost on restart, so it's not accepted
+	err = m.store.TicketQueued(ticketRecord(group))
+	if err != nil {
+		return err
+	}
+	m.listener.TicketQueued(group.ID)
 
 	m.searchQueue.PushBack(group)
 	m.rankedTable.Add(group)
+	m.addSeed(group)
+	m.groupIDs[group.ID] = true
 
 	return nil
 }
 
-func (m *matchmaker) RemoveGroup(id string) {
+func (m *matchmaker) RemoveGroup(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	g := &Group{
 		ID: id,
 	}
@@ -105,20 +388,49 @@ func (m *matchmaker) RemoveGroup(id string) {
 		}
 	}
 	if group == nil {
-		return
+		return false
 	}
 
-	group.cancelSearch <- true
+	cancelGroup(group, CancelledByRequest)
 
 	for i := range m.preparingMatchTeams {
 		m.preparingMatchTeams[i].remove(group)
 	}
 
 	m.removeGroupFromSearch(group)
+	delete(m.groupIDs, id)
+	m.journal(m.store.TicketsRemoved([]string{id}, RemovedCancelled))
+	m.logger.InfoContext(group.traceContext(), "group search cancelled", "group_id", id)
+	return true
 }
 
 func (m *matchmaker) SetPlayerReady(id int) {
-	m.waitingMatchPlayers[id].ready = true
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	player, waiting := m.waitingMatchPlayers[id]
+	if waiting {
+		player.ready = true
+	}
+}
+
+func (m *matchmaker) SetPlayerDeclined(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	player, waiting := m.waitingMatchPlayers[id]
+	if waiting {
+		player.declined = true
+	}
+}
+
+// Notifies the group why it left search. Channel is buffered by the caller,
+// so it's not written if the group was already notified or nobody waits for it.
+func cancelGroup(group *Group, reason CancelReason) {
+	select {
+	case group.cancelSearch <- reason:
+	default:
+	}
 }
 
 func (m *matchmaker) removeGroupFromSearch(group *Group) {
@@ -131,6 +443,7 @@ func (m *matchmaker) removeGroupFromSearch(group *Group) {
 	}
 
 	m.rankedTable.Delete(group)
+	m.removeSeed(group)
 }
 
 func (m *matchmaker) removeTeamsFromSearch(teams []Team) {
@@ -142,8 +455,10 @@ func (m *matchmaker) removeTeamsFromSearch(teams []Team) {
 }
 
 func (m *matchmaker) returnGroupToSearch(group *Group) {
+	group.SelectedForMatch = false
 	m.searchQueue.PushBack(group)
 	m.rankedTable.Add(group)
+	m.addSeed(group)
 }
 
 func (m *matchmaker) makeMatch() {
@@ -151,22 +466,45 @@ func (m *matchmaker) makeMatch() {
 		return
 	}
 
-	m.preparingMatchTeams = make([]Team, m.params.TeamCount)
-	firstInQueue := m.searchQueue.Front()
-	group := firstInQueue.Value.(*Group)
-	m.preparingMatchTeams[0].add(group)
-	group.SelectedForMatch = true
-	avgRating := group.AvgRating
+	group := m.selectSeed()
+	if group == nil {
+		return
+	}
 
+	start := time.Now()
+	matched := false
+	_, span := tracer().Start(group.traceContext(), "matchmaker.MatchPass", trace.WithAttributes(
+		attribute.String("group.id", group.ID),
+		attribute.Int("queue.depth", m.searchQueue.Len()),
+	))
+	m.consideredGroups = make(map[*Group]bool)
+	defer func() {
+		m.metrics.MatchPass(time.Since(start), !matched)
+		span.SetAttributes(attribute.Bool("matched", matched))
+		m.traceConsideredGroups(group, span)
+		span.End()
+	}()
+
+	m.preparingMatchTeams = newTeams(m.params)
+	joined := false
+	for i := range m.preparingMatchTeams {
+		if m.preparingMatchTeams[i].join(group, m.roleFallbackDepth(group)) {
+			joined = true
+			break
+		}
+	}
+	if !joined {
+		return
+	}
+	group.SelectedForMatch = true
 	allTeamsFull := false
 	for !allTeamsFull {
-		for i := range m.preparingMatchTeams {
-			err := m.preparingMatchTeams[i].fill(m, avgRating)
-			if err != nil {
+		if !m.planTeams(group) {
+			if !m.fillPartialMatch(group) {
 				m.resetGroupsInRankedTable(m.preparingMatchTeams)
-				m.searchQueue.MoveToBack(firstInQueue)
 				return
 			}
+			break
 		}
 		// Groups can cancel (exit) search at any time
 		allTeamsFull = m.checkAllTeamsFull(m.preparingMatchTeams)
@@ -175,27 +513,120 @@ func (m *matchmaker) makeMatch() {
 	m.removeTeamsFromSearch(m.preparingMatchTeams)
 	teams := make([]Team, len(m.preparingMatchTeams))
 	copy(teams, m.preparingMatchTeams)
-	go m.createMatch(teams)
+	for _, team := range teams {
+		for _, g := range team.groups {
+			m.metrics.TicketWaited(g.AvgRating, g.Size, time.Since(g.queuedAt))
+		}
+	}
+	matched = true
+	m.metrics.MatchCreated()
+	match := newMatch(teams, m.params)
+	m.journal(m.store.TicketsMatching(match.ID, groupIDs(teams)))
+	m.listener.TicketsMatching(match.ID, groupIDs(teams))
+	params := m.params
+	m.inFlight.Add(1)
+	go func() {
+		defer m.inFlight.Done()
+		m.createMatch(match, params)
+	}()
 }
 
-func (m *matchmaker) findGroupWithSameRating(avgRating int, size int) (*Group, error) {
-	for i := 0; i < m.params.MaxRatingSpreadToSearch; i++ {
-		groups, found := m.rankedTable.Get(avgRating + i)
-		if !found {
-			groups, found = m.rankedTable.Get(avgRating - i)
+// Fills teams with any groups that fit, then trims teams for a match that is not full.
+// Teams get their min size first and are topped up only after that, so more players can play.
+func (m *matchmaker) fillPartialMatch(seed *Group) bool {
+	if !m.params.PartialMatchesAllowed() {
+		return false
+	}
+
+	teams := m.preparingMatchTeams
+	for i := range teams {
+		if teams[i].fill(m, seed, teams[i].minSize) != nil && !teams[i].has(seed) {
+			// Team can't play, its groups may top up other teams
+			m.resetGroupsInRankedTable(teams[i : i+1])
+			for _, group := range append([]*Group(nil), teams[i].groups...) {
+				teams[i].remove(group)
+			}
 		}
+	}
+	for i := range teams {
+		if teams[i].numPlayers >= teams[i].minSize {
+			_ = teams[i].fill(m, seed, teams[i].size)
+		}
+	}
 
-		if found {
-			for j := range groups {
-				if (groups[j].Size <= size) && (!groups[j].SelectedForMatch) {
-					groups[j].SelectedForMatch = true
-					return groups[j], nil
-				}
+	return m.trimPartialMatch(seed) && m.checkPartyFairness(m.preparingMatchTeams, seed)
+}
+
+// Leaves only teams which can play in a match that is not full.
+// Returns false if it's too early to start such match or there are not enough teams.
+func (m *matchmaker) trimPartialMatch(seed *Group) bool {
+	if !m.params.PartialMatchesAllowed() {
+		return false
+	}
+
+	numPlayers := 0
+	for _, team := range m.preparingMatchTeams {
+		numPlayers += team.numPlayers
+	}
+
+	timeout := time.Duration(m.params.SecondsToStartPartialMatch) * time.Second
+	timedOut := m.params.SecondsToStartPartialMatch > 0 && time.Since(seed.queuedAt) >= timeout
+	populated := m.params.PlayersToStartPartialMatch > 0 && numPlayers >= m.params.PlayersToStartPartialMatch
+	if !timedOut && !populated {
+		return false
+	}
+
+	teams := make([]Team, 0, len(m.preparingMatchTeams))
+	dropped := make([]Team, 0)
+	for _, team := range m.preparingMatchTeams {
+		if team.numPlayers > 0 && team.numPlayers >= team.minSize {
+			teams = append(teams, team)
+		} else {
+			dropped = append(dropped, team)
+		}
+	}
+
+	minTeamCount := m.params.MinTeamCount
+	if minTeamCount <= 0 {
+		minTeamCount = len(m.preparingMatchTeams)
+	}
+	if len(teams) < minTeamCount {
+		return false
+	}
+
+	for _, team := range dropped {
+		for _, group := range team.groups {
+			if groupsEqual(group, seed) {
+				return false
 			}
 		}
 	}
 
-	return nil, errors.New("can't find group with similar rating")
+	m.resetGroupsInRankedTable(dropped)
+	m.preparingMatchTeams = teams
+	return true
+}
+
+func (m *matchmaker) findGroupWithSameRating(avgRating int, maxSize int, fits func(g *Group) bool) (*Group, error) {
+	group := m.rankedTable.Nearest(avgRating, m.params.MaxRatingSpreadToSearch, maxSize, func(g *Group) bool {
+		return !g.SelectedForMatch && fits(g)
+	})
+	if group == nil {
+		return nil, errors.New("can't find group with similar rating")
+	}
+
+	group.SelectedForMatch = true
+	m.consider(group)
+	return group, nil
+}
+
+// Number of preferred roles which can be assigned to players of the group
+func (m *matchmaker) roleFallbackDepth(group *Group) int {
+	if m.params.SecondsToRoleFallback <= 0 {
+		return 0
+	}
+
+	return 1 + int(time.Since(group.queuedAt)/(time.Duration(m.params.SecondsToRoleFallback)*time.Second))
 }
 
 func (m *matchmaker) resetGroupsInRankedTable(teams []Team) {
@@ -208,7 +639,7 @@ func (m *matchmaker) resetGroupsInRankedTable(teams []Team) {
 
 func (m *matchmaker) checkAllTeamsFull(matchTeams []Team) (ok bool) {
 	for i := range matchTeams {
-		if matchTeams[i].numPlayers < m.params.TeamSize {
+		if matchTeams[i].numPlayers < matchTeams[i].size {
 			return false
 		}
 	}
@@ -216,26 +647,109 @@ func (m *matchmaker) checkAllTeamsFull(matchTeams []Team) (ok bool) {
 	return true
 }
 
-func (m *matchmaker) createMatch(teams []Team) {
-	if !m.params.CheckReadiness {
-		serverID := m.matchReadyCallback(teams, m.serverConfig.ServerManagerAddr)
-		m.notifyMatchFound(teams, serverID)
+// Params of the match are passed by makeMatch, so they don't change while it's created
+func (m *matchmaker) createMatch(match *Match, params *config.MatchmakerConfig) {
+	teams := match.Teams
+	m.logger.Info("match found", "match_id", match.ID, "group_ids", groupIDs(teams),
+		"quality_score", match.Quality.Score, "rating_spread", match.Quality.RatingSpread,
+		"party_size_delta", match.Quality.PartySizeDelta, "rating_adjustments", match.RatingAdjustments)
+	if !params.CheckReadiness {
+		m.startMatch(match)
 	} else {
+		check := &readyCheck{
+			teams:    teams,
+			deadline: time.Now().Add(time.Duration(params.SecondsToAcceptMatch) * time.Second),
+		}
+		m.mu.Lock()
 		m.addWaitingPlayers(teams)
-		allPlayersReady, notReadyPlayers := m.checkAllPlayersReady(teams)
+		m.readyChecks[check] = true
+		m.mu.Unlock()
+
+		spans := startGroupSpans(teams, "matchmaker.ReadyCheck")
+		outcome, notReadyPlayers := m.checkAllPlayersReady(teams, params)
+		allPlayersReady := outcome == ReadyCheckAccepted
+		for _, span := range spans {
+			span.SetAttributes(attribute.String("outcome", outcome), attribute.Int("players.not_ready", len(notReadyPlayers)))
+			span.End()
+		}
+		m.metrics.ReadyCheckFinished(outcome)
 		if allPlayersReady {
-			serverID := m.matchReadyCallback(teams, m.serverConfig.ServerManagerAddr)
-			m.notifyMatchFound(teams, serverID)
-		} else {
+			m.startMatch(match)
+		}
+
+		m.mu.Lock()
+		if !allPlayersReady {
+			m.logger.Info("match is not accepted", "match_id", match.ID, "not_ready_player_ids", playerIDs(notReadyPlayers))
 			// Groups where any of players didn't accept the match are removed from search
 			m.returnGroupsToSearch(teams, notReadyPlayers)
 
-			if m.params.PenaltyForUnacceptedMatch {
+			if params.PenaltyForUnacceptedMatch {
 				m.addPenalty(notReadyPlayers)
 			}
 		}
 		m.removeWaitingPlayers(teams)
+		delete(m.readyChecks, check)
+		m.mu.Unlock()
+	}
+}
+
+// Requests server for the match and notifies groups.
+// If server is not allocated, groups are returned to search.
+func (m *matchmaker) startMatch(match *Match) {
+	// Span is a child of the first group's trace and linked to traces of other groups
+	var parent context.Context
+	var links []trace.Link
+	for _, team := range match.Teams {
+		for _, group := range team.groups {
+			if parent == nil {
+				parent = group.traceContext()
+			} else {
+				links = append(links, trace.LinkFromContext(group.traceContext()))
+			}
+		}
+	}
+	ctx, span := tracer().Start(parent, "matchmaker.RequestServer", trace.WithLinks(links...))
+
+	start := time.Now()
+	serverID, err := m.matchReadyCallback(ctx, match, m.serverConfig.ServerManagerAddr)
+	m.metrics.ServerAllocated(time.Since(start), err)
+	endSpan(span, err)
+	if err != nil {
+		m.logger.ErrorContext(ctx, "failed to allocate server, groups are returned to search", "match_id", match.ID, "error", err)
+		m.mu.Lock()
+		m.returnGroupsToSearch(match.Teams, nil)
+		m.mu.Unlock()
+		return
+	}
+
+	m.logger.InfoContext(ctx, "server allocated", "match_id", match.ID, "server_id", serverID)
+	m.mu.Lock()
+	for _, id := range groupIDs(match.Teams) {
+		delete(m.groupIDs, id)
+	}
+	m.mu.Unlock()
+	m.journal(m.store.TicketsRemoved(groupIDs(match.Teams), RemovedMatched))
+	m.notifyMatchFound(match.Teams, serverID)
+}
+
+func groupIDs(teams []Team) []string {
+	var ids []string
+	for _, team := range teams {
+		for _, group := range team.groups {
+			ids = append(ids, group.ID)
+		}
+	}
+
+	return ids
+}
+
+func playerIDs(players []*Player) []int {
+	ids := make([]int, len(players))
+	for i, player := range players {
+		ids[i] = player.ID
 	}
+
+	return ids
 }
 
 func (m *matchmaker) addWaitingPlayers(teams []Team) {
@@ -262,9 +776,13 @@ func (m *matchmaker) removeWaitingPlayers(teams []Team) {
 // so in most cases we have to check that they are ready to play,
 // which can be done explicitly (players press 'Accept' button) or
 // implicitly (automatically send 'player ready' request after 'match ready' response).
-func (m *matchmaker) checkAllPlayersReady(teams []Team) (success bool, notReadyPlayers []*Player) {
-	var allPlayersReady bool
-	timer := time.Duration(m.params.SecondsToAcceptMatch) * time.Second
+// Check ends early if any player declines the match, then only players who declined are not ready.
+func (m *matchmaker) checkAllPlayersReady(teams []Team, params *config.MatchmakerConfig) (outcome string, notReadyPlayers []*Player) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var allPlayersReady, declined bool
+	timer := time.Duration(params.SecondsToAcceptMatch) * time.Second
 	for start := time.Now(); time.Since(start) < timer; {
 		allPlayersReady = true
 		for _, team := range teams {
@@ -273,62 +791,79 @@ func (m *matchmaker) checkAllPlayersReady(teams []Team) (success bool, notReadyP
 					if !player.ready {
 						allPlayersReady = false
 					}
+					if player.declined {
+						declined = true
+					}
 				}
 
 			}
 		}
 		if allPlayersReady {
-			return true, nil
+			return ReadyCheckAccepted, nil
 		}
+		if declined {
+			break
+		}
+
+		// Players become ready while the lock is released
+		m.mu.Unlock()
+		time.Sleep(readyCheckInterval)
+		m.mu.Lock()
 	}
 
 	notReady := make([]*Player, 0)
 	for i, team := range teams {
 		for j, group := range team.groups {
 			for k, player := range group.Players {
-				if !player.ready {
+				if declined && player.declined || !declined && !player.ready {
 					notReady = append(notReady, &teams[i].groups[j].Players[k])
 				}
 			}
 		}
 	}
 
-	return allPlayersReady, notReady
+	if declined {
+		return ReadyCheckDeclined, notReady
+	}
+	return ReadyCheckTimedOut, notReady
 }
 
 func (m *matchmaker) returnGroupsToSearch(teams []Team, notReadyPlayers []*Player) {
-	for i, team := range teams {
-		for j, group := range team.groups {
-			for _, player := range group.Players {
-				for _, notReady := range notReadyPlayers {
-					if player.ID == notReady.ID {
-						m.removeGroupFromSearch(teams[i].groups[j])
-					} else {
-						m.returnGroupToSearch(teams[i].groups[j])
-					}
-				}
+	for _, team := range teams {
+		for _, group := range team.groups {
+			if !hasAnyPlayer(group, notReadyPlayers) {
+				group.requeued = true
+				m.returnGroupToSearch(group)
+				m.journal(m.store.TicketQueued(ticketRecord(group)))
+				m.listener.TicketQueued(group.ID)
+			} else {
+				cancelGroup(group, CancelledNotReady)
+				delete(m.groupIDs, group.ID)
+				m.journal(m.store.TicketsRemoved([]string{group.ID}, RemovedDeclined))
 			}
 		}
 	}
 }
 
+func hasAnyPlayer(group *Group, players []*Player) bool {
+	for _, player := range group.Players {
+		for _, p := range players {
+			if player.ID == p.ID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (m *matchmaker) checkRatingSpread(group *Group) error {
 	if m.params.MaxRatingSpreadInGroup < 0 {
 		return nil
 	}
 
-	min, max := 0, 0
-	for _, player := range group.Players {
-		if player.Rating < min {
-			min = player.Rating
-		}
-		if player.Rating > max {
-			max = player.Rating
-		}
-	}
-
-	if max-min > m.params.MaxRatingSpreadInGroup {
-		return errors.New("spread of rating in the group is too high")
+	if group.ratingSpread() > m.params.MaxRatingSpreadInGroup {
+		return &AdmissionError{Rule: "maxRatingSpreadInGroup"}
 	}
 
 	return nil
@@ -336,8 +871,10 @@ func (m *matchmaker) checkRatingSpread(group *Group) error {
 
 func (m *matchmaker) addPenalty(players []*Player) {
 	t := time.Now()
+	expiresAt := t.Add(time.Duration(m.params.PenaltySeconds) * time.Second)
 	for _, player := range players {
 		m.penalizedPlayers[player.ID] = t
+		m.journal(m.store.PlayerPenalized(PenaltyRecord{PlayerID: player.ID, At: t, ExpiresAt: expiresAt}))
 	}
 }
 
@@ -356,42 +893,65 @@ func (m *matchmaker) checkPenalty(group *Group) error {
 	return nil
 }
 
-func RequestServer(teams []Team, sendTo string) string {
-	teamsAndPlayerIds := make([][]int, len(teams))
-	for i, team := range teams {
+type allocatedPlayer struct {
+	ID   int    `json:"id"`
+	Role string `json:"role,omitempty"`
+}
+
+type allocationRequest struct {
+	Teams             [][]allocatedPlayer `json:"teams"`
+	Quality           MatchQuality        `json:"quality"`
+	RatingAdjustments map[int]int         `json:"ratingAdjustments,omitempty"`
+}
+
+// Trace context of the request is sent to the server manager
+func RequestServer(ctx context.Context, match *Match, sendTo string) (string, error) {
+	if sendTo == "" {
+		return "", errors.New("server manager address is not set")
+	}
+
+	teamsAndPlayers := make([][]allocatedPlayer, len(match.Teams))
+	for i, team := range match.Teams {
 		for _, group := range team.groups {
-			for k, player := range group.Players {
-				teamsAndPlayerIds[i][k] = player.ID
+			for _, player := range group.Players {
+				teamsAndPlayers[i] = append(teamsAndPlayers[i], allocatedPlayer{ID: player.ID, Role: player.Role})
 			}
 		}
 	}
 
-	reqBody, err := json.Marshal(teamsAndPlayerIds)
+	reqBody, err := json.Marshal(allocationRequest{
+		Teams:             teamsAndPlayers,
+		Quality:           match.Quality,
+		RatingAdjustments: match.RatingAdjustments,
+	})
 	if err != nil {
-		log.Fatalf("failed to marshall teams: %s", err)
+		return "", fmt.Errorf("failed to marshall teams: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", sendTo, bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", sendTo, bytes.NewReader(reqBody))
 	if err != nil {
-		log.Printf("failed to build request: %s", err)
+		return "", fmt.Errorf("failed to build request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := http.Client{Timeout: 10 * time.Second}
+	client := http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	res, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("failed to send request: %s", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer res.Body.Close()
 
 	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Fatalf("failed to read body of response: %s", err)
+		return "", fmt.Errorf("failed to read body of response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server manager responded with %s: %s", res.Status, resBody)
 	}
 
 	serverId := string(resBody)
-	return serverId
+	return serverId, nil
 }
 
 func (m *matchmaker) notifyMatchFound(teams []Team, serverID string) {
@@ -2,12 +2,16 @@ package matchmaker
 
 import (
 	"goplay/config"
+	"goplay/repository"
+	"goplay/repository/repositorytest"
 
-	"container/list"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
 	"os"
+	"slices"
 	"strconv"
 	"testing"
 	"time"
@@ -27,14 +31,7 @@ func TestLowPlayerCount(t *testing.T) {
 		},
 	}
 
-	mm := &matchmaker{
-		repository:         nil,
-		searchQueue:        list.New(),
-		rankedTable:        make(RankedGroupsTable),
-		params:             &cfg.Matchmaker,
-		serverConfig:       &cfg.Server,
-		matchReadyCallback: writeToFile,
-	}
+	mm := NewMatchmaker(nil, cfg, writeToFile).(*matchmaker)
 
 	groups := generateGroups(numPlayers, 5, 1000)
 
@@ -72,7 +69,7 @@ func generateGroups(count int, maxPlayers, maxRating int) []Group {
 	return groups
 }
 
-func writeToFile(teams []Team, filename string) string {
+func writeToFile(ctx context.Context, match *Match, filename string) (string, error) {
 	f, err := os.OpenFile("mm_test.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatal(err)
@@ -84,10 +81,10 @@ func writeToFile(teams []Team, filename string) string {
 		Teams [][]Player
 	}{
 		ID:    rand.Intn(10000),
-		Teams: make([][]Player, len(teams)),
+		Teams: make([][]Player, len(match.Teams)),
 	}
 
-	for i, team := range teams {
+	for i, team := range match.Teams {
 		for _, group := range team.groups {
 			matchInfo.Teams[i] = append(matchInfo.Teams[i], group.Players...)
 		}
@@ -100,5 +97,353 @@ func writeToFile(teams []Team, filename string) string {
 		log.Fatal(err)
 	}
 
-	return ""
+	return "", nil
+}
+
+func TestRoleComposition(t *testing.T) {
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{
+			TeamSize:                5,
+			TeamCount:               2,
+			MaxRatingSpreadToSearch: 100,
+			MaxRatingSpreadInGroup:  -1,
+			Roles:                   map[string]int{"tank": 1, "support": 1, "carry": 3},
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(&cfg.Matchmaker, nil, matches)
+
+	preferences := [][]string{
+		{"carry", "tank"}, {"carry", "support"}, {"carry"}, {"carry", "tank"}, {"carry", "support"},
+		{"carry"}, {"carry"}, {"support", "carry"}, {"tank"}, {},
+	}
+	for i, roles := range preferences {
+		mm.returnGroupToSearch(&Group{
+			ID:         strconv.Itoa(i),
+			Players:    []Player{{ID: i, Rating: 100, roles: roles}},
+			Size:       1,
+			AvgRating:  100,
+			matchFound: make(chan string, 1),
+		})
+	}
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		for i, team := range match.Teams {
+			roles := make(map[string]int)
+			for _, group := range team.groups {
+				for _, player := range group.Players {
+					roles[player.Role]++
+				}
+			}
+			for role, count := range cfg.Matchmaker.Roles {
+				if roles[role] != count {
+					t.Errorf("team %d: got %d %s, want %d", i, roles[role], role, count)
+				}
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestRoleFallback(t *testing.T) {
+	composition := map[string]int{"tank": 1, "carry": 1}
+	team := Team{size: 2, composition: composition}
+	team.add(&Group{Players: []Player{{Role: "carry"}}, Size: 1})
+
+	group := &Group{Players: []Player{{roles: []string{"carry", "tank"}}}, Size: 1}
+	if team.canJoin(group, 1) {
+		t.Errorf("secondary role assigned before fallback")
+	}
+	if roles, ok := team.rolesFor(group, 2); !ok || roles[0] != "tank" {
+		t.Errorf("got roles %v, want tank", roles)
+	}
+	// Probing doesn't change roles of the group, it gets them when it joins
+	if group.Players[0].Role != "" {
+		t.Errorf("got role %q of probed group, want none", group.Players[0].Role)
+	}
+	if !team.join(group, 2) || group.Players[0].Role != "tank" {
+		t.Errorf("got role %q of joined group, want tank", group.Players[0].Role)
+	}
+}
+
+func TestAsymmetricTeams(t *testing.T) {
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{
+			MaxRatingSpreadToSearch: 100,
+			MaxRatingSpreadInGroup:  -1,
+			Teams: []config.TeamSlotConfig{
+				{Name: "hunter", Size: 1, Role: "hunter"},
+				{Name: "survivors", Size: 4},
+			},
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(&cfg.Matchmaker, nil, matches)
+
+	groups := []*Group{
+		{ID: "duo", Players: []Player{{ID: 1}, {ID: 2}}, Size: 2},
+		{ID: "solo", Players: []Player{{ID: 3}}, Size: 1},
+		{ID: "hunter", Players: []Player{{ID: 4, roles: []string{"hunter"}}}, Size: 1},
+		{ID: "solo2", Players: []Player{{ID: 5}}, Size: 1},
+	}
+	for _, group := range groups {
+		group.matchFound = make(chan string, 1)
+		mm.returnGroupToSearch(group)
+	}
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		if len(match.Teams[0].groups) != 1 || match.Teams[0].groups[0].ID != "hunter" {
+			t.Errorf("hunter slot is not taken by the hunter group")
+		}
+		if match.Teams[1].numPlayers != 4 {
+			t.Errorf("got %d survivors, want %d", match.Teams[1].numPlayers, 4)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestPartialMatch(t *testing.T) {
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{
+			MaxRatingSpreadToSearch:    100,
+			MaxRatingSpreadInGroup:     -1,
+			MaxTeamCount:               4,
+			MaxTeamSize:                3,
+			MinTeamCount:               2,
+			MinTeamSize:                2,
+			SecondsToStartPartialMatch: 60,
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(&cfg.Matchmaker, nil, matches)
+
+	groups := make([]*Group, 7)
+	for i := range groups {
+		groups[i] = &Group{
+			ID:         strconv.Itoa(i),
+			Players:    []Player{{ID: i}},
+			Size:       1,
+			queuedAt:   time.Now(),
+			matchFound: make(chan string, 1),
+		}
+		mm.returnGroupToSearch(groups[i])
+	}
+
+	mm.makeMatch()
+	if mm.searchQueue.Len() != len(groups) {
+		t.Fatalf("partial match started before timeout")
+	}
+
+	for _, group := range groups {
+		group.queuedAt = time.Now().Add(-time.Minute)
+	}
+	mm.makeMatch()
+
+	// 3+2+2 seats all players, while filling teams one by one leaves one of them
+	select {
+	case match := <-matches:
+		if len(match.Teams) != 3 {
+			t.Errorf("got %d teams, want %d", len(match.Teams), 3)
+		}
+		sizes := make([]int, len(match.Teams))
+		for i, team := range match.Teams {
+			sizes[i] = team.numPlayers
+		}
+		slices.Sort(sizes)
+		if !slices.Equal(sizes, []int{2, 2, 3}) {
+			t.Errorf("got teams of %v players, want 3, 2 and 2", sizes)
+		}
+		if mm.searchQueue.Len() != 0 {
+			t.Errorf("got %d groups in search, want %d", mm.searchQueue.Len(), 0)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestPlanGroupSizes(t *testing.T) {
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{
+			TeamSize:                5,
+			TeamCount:               2,
+			MaxRatingSpreadToSearch: 100,
+			MaxRatingSpreadInGroup:  -1,
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(&cfg.Matchmaker, nil, matches)
+
+	// Taking groups closest by rating first would leave the second team with 2 and 4 players
+	sizes := []int{1, 3, 1, 2, 4}
+	uid := 0
+	for i, size := range sizes {
+		players := make([]Player, size)
+		for j := range players {
+			players[j] = Player{ID: uid, Rating: 100 + i}
+			uid++
+		}
+		group := &Group{
+			ID:         strconv.Itoa(i),
+			Players:    players,
+			Size:       size,
+			AvgRating:  100 + i,
+			matchFound: make(chan string, 1),
+		}
+		mm.returnGroupToSearch(group)
+	}
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		for i, team := range match.Teams {
+			if team.numPlayers != 5 {
+				t.Errorf("team %d: got %d players, want %d", i, team.numPlayers, 5)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestPartyFairness(t *testing.T) {
+	maxDelta := 1
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{
+			TeamSize:                5,
+			TeamCount:               2,
+			MaxRatingSpreadToSearch: 100,
+			MaxRatingSpreadInGroup:  -1,
+			PartyFairness:           &config.PartyFairnessConfig{MaxPartySizeDelta: &maxDelta, FullPremadesOnly: true},
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(&cfg.Matchmaker, nil, matches)
+
+	// Solo players are closer by rating to the seed premade than another premade
+	sizes := []int{5, 1, 1, 1, 1, 1, 5}
+	uid := 0
+	for i, size := range sizes {
+		players := make([]Player, size)
+		for j := range players {
+			players[j] = Player{ID: uid, Rating: 100 + i}
+			uid++
+		}
+		group := &Group{
+			ID:         strconv.Itoa(i),
+			Players:    players,
+			Size:       size,
+			queuedAt:   time.Now(),
+			matchFound: make(chan string, 1),
+		}
+		group.calcRating()
+		mm.returnGroupToSearch(group)
+	}
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		for i, team := range match.Teams {
+			if team.largestParty() != 5 {
+				t.Errorf("team %d: got largest party %d, want %d", i, team.largestParty(), 5)
+			}
+		}
+		if match.Quality.PartySizeDelta != 0 || match.Quality.RatingSpread != 6 {
+			t.Errorf("got quality %+v, want party size delta 0 and rating spread 6", match.Quality)
+		}
+		if match.Quality.Score <= 0 || match.Quality.Score >= 1 {
+			t.Errorf("got score %f, want between 0 and 1", match.Quality.Score)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
+
+func TestPartySizeDeltaNotSet(t *testing.T) {
+	params := &config.MatchmakerConfig{TeamSize: 3, TeamCount: 2, PartyFairness: &config.PartyFairnessConfig{FullPremadesOnly: true}}
+	mm := newTestMatchmaker(params, nil, nil)
+
+	teams := newTeams(params)
+	teams[0].add(&Group{ID: "1", Players: make([]Player, 2), Size: 2})
+	teams[0].add(&Group{ID: "2", Players: make([]Player, 1), Size: 1})
+	for i := 3; i < 6; i++ {
+		teams[1].add(&Group{ID: strconv.Itoa(i), Players: make([]Player, 1), Size: 1})
+	}
+
+	if !mm.checkPartyFairness(teams, &Group{queuedAt: time.Now()}) {
+		t.Error("party size delta is checked, want only full premades rule")
+	}
+}
+
+func TestUpdateParamsKeepsGroups(t *testing.T) {
+	cfg := &config.Config{
+		Matchmaker: config.MatchmakerConfig{TeamSize: 2, TeamCount: 2, MaxRatingSpreadToSearch: 10},
+	}
+	mm := NewMatchmaker(nil, cfg, nil).(*matchmaker)
+	for i := 0; i < 3; i++ {
+		mm.returnGroupToSearch(&Group{ID: strconv.Itoa(i), Players: []Player{{ID: i}}, Size: 1})
+	}
+
+	mm.UpdateParams(&config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 20, RequeuePriorityBoost: 30})
+
+	if mm.searchQueue.Len() != 3 || mm.rankedTable.Len() != 3 {
+		t.Errorf("got %d groups in search, want %d", mm.searchQueue.Len(), 3)
+	}
+	if mm.params.MaxRatingSpreadToSearch != 20 {
+		t.Errorf("params are not updated")
+	}
+	if boost := mm.priority(&Group{requeued: true, queuedAt: time.Now()}); boost < 30 {
+		t.Errorf("got requeue priority %f, want %d", boost, 30)
+	}
+}
+
+func TestAddGroupTwice(t *testing.T) {
+	cfg := &config.Config{Matchmaker: config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100,
+		CheckReadiness: true, SecondsToAcceptMatch: 5}}
+	players := make(map[int]repository.PlayerInfo)
+	for id := 1; id <= 3; id++ {
+		players[id] = repository.PlayerInfo{ID: uint64(id), Rating: 100}
+	}
+	mm := NewMatchmaker(&repositorytest.Repository{Players: players}, cfg, func(ctx context.Context, match *Match, sendTo string) (string, error) {
+		return "", nil
+	}).(*matchmaker)
+	add := func(id string, playerID int) (chan CancelReason, error) {
+		cancelled := make(chan CancelReason, 1)
+		return cancelled, mm.AddGroup(context.Background(), id, []int{playerID}, nil, make(chan string, 1), cancelled)
+	}
+
+	cancelled, _ := add("1", 1)
+	if _, err := add("1", 3); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("got %v of group in search, want %v", err, ErrGroupExists)
+	}
+	add("2", 2)
+
+	// Group in ready check has left search, but it's still in the queue
+	mm.RunPass()
+	for len(mm.Stats(100, 0).ReadyChecks) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := add("1", 3); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("got %v of group in ready check, want %v", err, ErrGroupExists)
+	}
+
+	mm.SetPlayerDeclined(1)
+	<-cancelled
+	if _, err := add("1", 1); err != nil {
+		t.Errorf("got %v, want group added again after it left the queue", err)
+	}
 }
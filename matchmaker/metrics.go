@@ -0,0 +1,41 @@
+package matchmaker
+
+import (
+	"time"
+)
+
+const (
+	ReadyCheckAccepted = "accepted"
+	ReadyCheckDeclined = "declined"
+	ReadyCheckTimedOut = "timed_out"
+)
+
+// Receives measurements of one queue, implementation decides how to export them
+type Metrics interface {
+	// Number of groups in search
+	QueueDepth(depth int)
+	// Time the group waited in search before it got into a match
+	TicketWaited(rating, size int, wait time.Duration)
+	MatchCreated()
+	ReadyCheckFinished(outcome string)
+	// Latency of the server allocation request, err is not nil if it failed
+	ServerAllocated(latency time.Duration, err error)
+	// Duration of one matchmaking pass, seedFailed is true if the seed didn't get into a match
+	MatchPass(duration time.Duration, seedFailed bool)
+}
+
+// Replaces metrics which are not collected by default
+func WithMetrics(metrics Metrics) Option {
+	return func(m *matchmaker) {
+		m.metrics = metrics
+	}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) QueueDepth(depth int)                              {}
+func (noopMetrics) TicketWaited(rating, size int, wait time.Duration) {}
+func (noopMetrics) MatchCreated()                                     {}
+func (noopMetrics) ReadyCheckFinished(outcome string)                 {}
+func (noopMetrics) ServerAllocated(latency time.Duration, err error)  {}
+func (noopMetrics) MatchPass(duration time.Duration, seedFailed bool) {}
@@ -0,0 +1,103 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	mu               sync.Mutex
+	waited           []int
+	matches          int
+	readyChecks      []string
+	allocationErrors int
+	passes           int
+	failedSeeds      int
+}
+
+func (m *testMetrics) QueueDepth(depth int) {}
+
+func (m *testMetrics) TicketWaited(rating, size int, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.waited = append(m.waited, size)
+}
+
+func (m *testMetrics) MatchCreated() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.matches++
+}
+
+func (m *testMetrics) ReadyCheckFinished(outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readyChecks = append(m.readyChecks, outcome)
+}
+
+func (m *testMetrics) ServerAllocated(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.allocationErrors++
+	}
+}
+
+func (m *testMetrics) MatchPass(duration time.Duration, seedFailed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.passes++
+	if seedFailed {
+		m.failedSeeds++
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	params := &config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 5}
+	allocated := make(chan error, 1)
+	metrics := &testMetrics{}
+	mm := NewMatchmaker(&repositorytest.Repository{}, &config.Config{Matchmaker: *params}, func(ctx context.Context, match *Match, sendTo string) (string, error) {
+		err := errors.New("no free servers")
+		allocated <- err
+		return "", err
+	}, WithMetrics(metrics)).(*matchmaker)
+
+	// Ratings of the first two groups are too far from each other
+	addSoloGroups(t, mm, 1)
+	mm.returnGroupToSearch(&Group{ID: "2", Players: []Player{{ID: 2}}, Size: 1, AvgRating: 1000})
+	mm.RunPass()
+	if metrics.passes != 1 || metrics.failedSeeds != 1 {
+		t.Errorf("got %d passes and %d failed seeds, want 1 and 1", metrics.passes, metrics.failedSeeds)
+	}
+
+	addSoloGroups(t, mm, 3)
+	for metrics.matches == 0 && metrics.passes < 10 {
+		mm.RunPass()
+	}
+	<-allocated
+
+	// Groups are returned to search after the callback
+	depth := 0
+	for start := time.Now(); depth < 3 && time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		mm.mu.Lock()
+		depth = mm.searchQueue.Len()
+		mm.mu.Unlock()
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.matches != 1 || len(metrics.waited) != 2 {
+		t.Errorf("got %d matches and %d waited groups, want 1 and 2", metrics.matches, len(metrics.waited))
+	}
+	if metrics.allocationErrors != 1 {
+		t.Errorf("got %d allocation failures, want %d", metrics.allocationErrors, 1)
+	}
+	if depth != 3 {
+		t.Errorf("got %d groups in search, want groups returned after failed allocation", depth)
+	}
+}
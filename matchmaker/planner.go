@@ -0,0 +1,152 @@
+package matchmaker
+
+const (
+	// Min number of candidates considered when planning teams
+	minPlanCandidates = 32
+	// Number of candidates considered per each free slot in teams
+	planCandidatesPerSlot = 4
+)
+
+// Plans combination of groups which exactly fills all teams and only then selects them for the match.
+// Candidates are groups within the rating window, closer by rating groups are tried first.
+func (m *matchmaker) planTeams(seed *Group) bool {
+	avgRating := seed.AvgRating
+	freeSlots, maxFree := 0, 0
+	for _, team := range m.preparingMatchTeams {
+		free := team.size - team.numPlayers
+		freeSlots += free
+		if free > maxFree {
+			maxFree = free
+		}
+	}
+	if freeSlots == 0 {
+		return true
+	}
+
+	limit := freeSlots * planCandidatesPerSlot
+	if limit < minPlanCandidates {
+		limit = minPlanCandidates
+	}
+	candidates := m.rankedTable.NearestN(avgRating, m.params.MaxRatingSpreadToSearch, maxFree, limit, func(g *Group) bool {
+		return !g.SelectedForMatch && g.pool == seed.pool
+	})
+	for _, g := range candidates {
+		m.consider(g)
+	}
+
+	p := &teamPlan{
+		m:          m,
+		seed:       seed,
+		teams:      m.preparingMatchTeams,
+		candidates: candidates,
+		used:       make([]bool, len(candidates)),
+		// Search fails if it can't fill teams in a few tries of each candidate for each slot
+		maxSteps: freeSlots * len(candidates),
+	}
+	// Groups of the same size are interchangeable if teams have no role constraints
+	// and players don't avoid each other
+	p.interchangeable = !m.avoidRulesEnabled()
+	for _, team := range m.preparingMatchTeams {
+		if len(team.composition) > 0 || team.requiredRole != "" {
+			p.interchangeable = false
+		}
+	}
+	if !p.fillTeam(0, 0) {
+		return false
+	}
+
+	for _, team := range m.preparingMatchTeams {
+		for _, group := range team.groups {
+			group.SelectedForMatch = true
+		}
+	}
+
+	return true
+}
+
+type teamPlan struct {
+	m          *matchmaker
+	seed       *Group
+	teams      []Team
+	candidates []*Group
+	used       []bool
+	steps      int
+	maxSteps   int
+	// Results of canSum for the team being filled
+	sums map[sumKey]bool
+	// Groups of the same size can replace each other
+	interchangeable bool
+}
+
+// Fills team with candidates starting from the given one, then the rest of teams.
+// Groups are added to teams while searching and removed when backtracking.
+func (p *teamPlan) fillTeam(teamIndex, from int) bool {
+	if teamIndex == len(p.teams) {
+		return p.m.checkPartyFairness(p.teams, p.seed)
+	}
+
+	team := &p.teams[teamIndex]
+	free := team.size - team.numPlayers
+	if free == 0 {
+		return p.fillTeam(teamIndex+1, 0)
+	}
+	if from == 0 {
+		// Candidates used by previous teams don't change while this one is filled
+		p.sums = make(map[sumKey]bool)
+	}
+
+	p.steps++
+	if p.steps > p.maxSteps || !p.canSum(from, free) {
+		return false
+	}
+
+	triedSizes := make(map[int]bool)
+	for i := from; i < len(p.candidates); i++ {
+		group := p.candidates[i]
+		if p.used[i] || group.Size > free || (p.interchangeable && triedSizes[group.Size]) {
+			continue
+		}
+		if p.m.conflicts(p.teams, team, group) || !team.join(group, p.m.roleFallbackDepth(group)) {
+			continue
+		}
+		triedSizes[group.Size] = true
+
+		p.used[i] = true
+		if p.fillTeam(teamIndex, i+1) {
+			return true
+		}
+		team.remove(group)
+		p.used[i] = false
+	}
+
+	return false
+}
+
+type sumKey struct {
+	from, target int
+}
+
+// Checks if sizes of unused candidates starting from the given one can sum up to the number of free slots
+func (p *teamPlan) canSum(from, target int) bool {
+	key := sumKey{from, target}
+	if ok, found := p.sums[key]; found {
+		return ok
+	}
+
+	reachable := make([]bool, target+1)
+	reachable[0] = true
+	for i := from; i < len(p.candidates) && !reachable[target]; i++ {
+		group := p.candidates[i]
+		if p.used[i] || group.Size > target {
+			continue
+		}
+		for sum := target; sum >= group.Size; sum-- {
+			if reachable[sum-group.Size] {
+				reachable[sum] = true
+			}
+		}
+	}
+
+	p.sums[key] = reachable[target]
+	return reachable[target]
+}
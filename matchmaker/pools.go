@@ -0,0 +1,62 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+
+	"time"
+)
+
+const (
+	FlagNewPlayer = "new"
+	FlagSmurf     = "smurf"
+)
+
+// Flags players who may need a separate pool, e.g. new accounts or suspected smurfs
+type PlayerFlagger interface {
+	Flags(player repository.PlayerInfo) []string
+}
+
+// Flags players by thresholds from config
+type ThresholdFlagger struct {
+	Params config.PlayerFlagsConfig
+}
+
+func (f ThresholdFlagger) Flags(player repository.PlayerInfo) []string {
+	var flags []string
+	maxAge := time.Duration(f.Params.NewPlayerMaxAccountAgeDays) * 24 * time.Hour
+	if player.GamesPlayed < f.Params.NewPlayerMaxGames ||
+		(f.Params.NewPlayerMaxAccountAgeDays > 0 && time.Since(player.CreatedAt) < maxAge) {
+		flags = append(flags, FlagNewPlayer)
+	}
+
+	if f.Params.SmurfMinScore > 0 && player.SmurfScore >= f.Params.SmurfMinScore {
+		flags = append(flags, FlagSmurf)
+	}
+
+	return flags
+}
+
+func (m *matchmaker) playerFlags(info repository.PlayerInfo) []string {
+	if m.flagger != nil {
+		return m.flagger.Flags(info)
+	}
+
+	return ThresholdFlagger{Params: m.params.PlayerFlags}.Flags(info)
+}
+
+// Chooses pool of the group by the first routing rule with flag of any of its players.
+// Groups are matched only with groups from the same pool.
+func (m *matchmaker) routeGroup(group *Group) string {
+	for _, rule := range m.params.PoolRouting {
+		for _, player := range group.Players {
+			for _, flag := range player.flags {
+				if flag == rule.Flag {
+					return rule.Pool
+				}
+			}
+		}
+	}
+
+	return ""
+}
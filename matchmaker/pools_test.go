@@ -0,0 +1,89 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThresholdFlagger(t *testing.T) {
+	flagger := ThresholdFlagger{Params: config.PlayerFlagsConfig{
+		NewPlayerMaxGames:          50,
+		NewPlayerMaxAccountAgeDays: 7,
+		SmurfMinScore:              0.8,
+	}}
+	old := time.Now().Add(-30 * 24 * time.Hour)
+
+	tests := []struct {
+		player repository.PlayerInfo
+		want   []string
+	}{
+		{repository.PlayerInfo{GamesPlayed: 100, CreatedAt: old}, nil},
+		{repository.PlayerInfo{GamesPlayed: 10, CreatedAt: old}, []string{FlagNewPlayer}},
+		{repository.PlayerInfo{GamesPlayed: 100, CreatedAt: time.Now()}, []string{FlagNewPlayer}},
+		{repository.PlayerInfo{GamesPlayed: 100, CreatedAt: old, SmurfScore: 0.9}, []string{FlagSmurf}},
+		{repository.PlayerInfo{GamesPlayed: 10, CreatedAt: old, SmurfScore: 0.9}, []string{FlagNewPlayer, FlagSmurf}},
+	}
+
+	for i, test := range tests {
+		got := flagger.Flags(test.player)
+		if len(got) != len(test.want) {
+			t.Errorf("case %d: got %v, want %v", i, got, test.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != test.want[j] {
+				t.Errorf("case %d: got %v, want %v", i, got, test.want)
+			}
+		}
+	}
+}
+
+func TestPoolRouting(t *testing.T) {
+	params := &config.MatchmakerConfig{
+		TeamSize:                1,
+		TeamCount:               2,
+		MaxRatingSpreadToSearch: 100,
+		PoolRouting: []config.PoolRoutingConfig{
+			{Flag: FlagSmurf, Pool: "smurfs"},
+			{Flag: FlagNewPlayer, Pool: "newcomers"},
+		},
+	}
+
+	matches := make(chan *Match, 1)
+	mm := newTestMatchmaker(params, nil, matches)
+
+	// New player is the closest by rating to the seed, but plays in another pool
+	flags := [][]string{{FlagNewPlayer}, {}, {FlagNewPlayer, FlagSmurf}, {FlagNewPlayer}}
+	for i, playerFlags := range flags {
+		group := &Group{
+			ID:         strconv.Itoa(i),
+			Players:    []Player{{ID: i, flags: playerFlags}},
+			Size:       1,
+			AvgRating:  100 + i,
+			matchFound: make(chan string, 1),
+		}
+		group.pool = mm.routeGroup(group)
+		mm.returnGroupToSearch(group)
+	}
+
+	if pool := mm.routeGroup(&Group{Players: []Player{{flags: flags[2]}}}); pool != "smurfs" {
+		t.Errorf("got pool %q, want smurfs", pool)
+	}
+
+	mm.makeMatch()
+
+	select {
+	case match := <-matches:
+		for _, team := range match.Teams {
+			if team.groups[0].pool != "newcomers" {
+				t.Errorf("got group from pool %q, want newcomers", team.groups[0].pool)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match was not created")
+	}
+}
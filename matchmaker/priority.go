@@ -0,0 +1,188 @@
+package matchmaker
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Source of priority used to choose which group seeds the next match.
+// Priorities of all sources are summed when the group enters search, group with the highest sum
+// is seeded first and the one which waits longer goes first among groups with the same priority.
+type PrioritySource interface {
+	Priority(group *Group) float64
+}
+
+// Boost for groups returned to search because someone else didn't accept the match
+type RequeuePriority struct {
+	Boost float64
+}
+
+func (p RequeuePriority) Priority(group *Group) float64 {
+	if group.requeued {
+		return p.Boost
+	}
+
+	return 0
+}
+
+// Boost for groups with priority tickets (e.g. premium or tournament), taken from player info
+type TicketPriority struct {
+	PointsPerLevel float64
+}
+
+func (p TicketPriority) Priority(group *Group) float64 {
+	return p.PointsPerLevel * float64(group.priority)
+}
+
+func (m *matchmaker) priority(group *Group) float64 {
+	var sum float64
+	for _, source := range m.prioritySources {
+		sum += source.Priority(group)
+	}
+
+	return sum
+}
+
+// Chooses group to seed the next match in order of priority, but groups which
+// were not seeded for longer than MaxSecondsToSeed go first.
+// Seed doesn't seed again until another group enters search, so groups which can't be matched
+// don't take all passes. When every group has seeded, all of them may seed again.
+func (m *matchmaker) selectSeed() *Group {
+	if m.seeds.Len() == 0 {
+		m.unparkSeeds()
+	}
+
+	seed := m.seeds.peek()
+	if oldest := m.unseeded.peek(); oldest != nil && m.params.MaxSecondsToSeed > 0 &&
+		time.Since(oldest.notSeededSince()) > time.Duration(m.params.MaxSecondsToSeed)*time.Second {
+		seed = oldest
+	}
+	if seed == nil {
+		return nil
+	}
+
+	seed.lastSeededAt = time.Now()
+	m.unseeded.fix(seed)
+	m.seeds.remove(seed)
+	if m.parkedSeeds == nil {
+		m.parkedSeeds = make(map[*Group]bool)
+	}
+	m.parkedSeeds[seed] = true
+
+	return seed
+}
+
+// Adds group which entered search to the seed order
+func (m *matchmaker) addSeed(group *Group) {
+	group.seedPriority = m.priority(group)
+	m.unparkSeeds()
+	m.seeds.push(group)
+	m.unseeded.push(group)
+}
+
+func (m *matchmaker) removeSeed(group *Group) {
+	m.seeds.remove(group)
+	m.unseeded.remove(group)
+	delete(m.parkedSeeds, group)
+}
+
+// Returns groups which already seeded to the seed order, new group in search may complete their matches
+func (m *matchmaker) unparkSeeds() {
+	for group := range m.parkedSeeds {
+		m.seeds.push(group)
+	}
+	clear(m.parkedSeeds)
+}
+
+// Recalculates priorities of groups in search after priority sources change
+func (m *matchmaker) updateSeedPriorities() {
+	for _, group := range m.seeds.groups {
+		group.seedPriority = m.priority(group)
+	}
+	for group := range m.parkedSeeds {
+		group.seedPriority = m.priority(group)
+	}
+	heap.Init(&m.seeds)
+}
+
+func (g *Group) notSeededSince() time.Time {
+	if g.lastSeededAt.IsZero() {
+		return g.queuedAt
+	}
+	return g.lastSeededAt
+}
+
+// Heap of groups in search, each group keeps its position, so it's removed without a scan
+type groupHeap struct {
+	groups []*Group
+	// Orders groups by time they were not seeded for instead of priority
+	byWait bool
+}
+
+func (h *groupHeap) index(g *Group) *int {
+	if h.byWait {
+		return &g.waitIndex
+	}
+	return &g.seedIndex
+}
+
+func (h *groupHeap) Len() int { return len(h.groups) }
+
+func (h *groupHeap) Less(i, j int) bool {
+	a, b := h.groups[i], h.groups[j]
+	if h.byWait {
+		return a.notSeededSince().Before(b.notSeededSince())
+	}
+	if a.seedPriority != b.seedPriority {
+		return a.seedPriority > b.seedPriority
+	}
+	return a.queuedAt.Before(b.queuedAt)
+}
+
+func (h *groupHeap) Swap(i, j int) {
+	h.groups[i], h.groups[j] = h.groups[j], h.groups[i]
+	*h.index(h.groups[i]) = i
+	*h.index(h.groups[j]) = j
+}
+
+func (h *groupHeap) Push(x any) {
+	group := x.(*Group)
+	*h.index(group) = len(h.groups)
+	h.groups = append(h.groups, group)
+}
+
+func (h *groupHeap) Pop() any {
+	last := len(h.groups) - 1
+	group := h.groups[last]
+	h.groups[last] = nil
+	h.groups = h.groups[:last]
+	return group
+}
+
+func (h *groupHeap) push(group *Group) {
+	heap.Push(h, group)
+}
+
+func (h *groupHeap) contains(group *Group) bool {
+	i := *h.index(group)
+	return i < len(h.groups) && h.groups[i] == group
+}
+
+func (h *groupHeap) remove(group *Group) {
+	if h.contains(group) {
+		heap.Remove(h, *h.index(group))
+	}
+}
+
+func (h *groupHeap) fix(group *Group) {
+	if h.contains(group) {
+		heap.Fix(h, *h.index(group))
+	}
+}
+
+func (h *groupHeap) peek() *Group {
+	if len(h.groups) == 0 {
+		return nil
+	}
+	return h.groups[0]
+}
@@ -0,0 +1,82 @@
+package matchmaker
+
+import (
+	"goplay/config"
+
+	"testing"
+	"time"
+)
+
+func newPriorityTestMatchmaker(params *config.MatchmakerConfig, groups ...*Group) *matchmaker {
+	mm := newTestMatchmaker(params, nil, nil, WithPrioritySources(
+		RequeuePriority{Boost: 600},
+		TicketPriority{PointsPerLevel: 60},
+	))
+
+	for _, group := range groups {
+		mm.returnGroupToSearch(group)
+	}
+
+	return mm
+}
+
+func TestSeedPriority(t *testing.T) {
+	now := time.Now()
+	old := &Group{ID: "old", queuedAt: now.Add(-5 * time.Minute)}
+	requeued := &Group{ID: "requeued", queuedAt: now, requeued: true}
+	premium := &Group{ID: "premium", queuedAt: now.Add(-time.Minute), priority: 5}
+	mm := newPriorityTestMatchmaker(&config.MatchmakerConfig{}, old, requeued, premium)
+
+	want := []string{"requeued", "premium", "old", "requeued"}
+	for i, id := range want {
+		seed := mm.selectSeed()
+		if seed == nil || seed.ID != id {
+			t.Fatalf("seed %d: got %v, want %s", i, seed, id)
+		}
+	}
+}
+
+func TestSeedFairness(t *testing.T) {
+	now := time.Now()
+	starving := &Group{ID: "starving", queuedAt: now.Add(-time.Minute), lastSeededAt: now.Add(-20 * time.Second)}
+	premium := &Group{ID: "premium", queuedAt: now, priority: 10}
+	mm := newPriorityTestMatchmaker(&config.MatchmakerConfig{MaxSecondsToSeed: 10}, premium, starving)
+
+	seed := mm.selectSeed()
+	if seed == nil || seed.ID != "starving" {
+		t.Fatalf("got %v, want starving", seed)
+	}
+
+	seed = mm.selectSeed()
+	if seed == nil || seed.ID != "premium" {
+		t.Fatalf("got %v, want premium", seed)
+	}
+}
+
+func TestFailedSeedWaitsForNewGroups(t *testing.T) {
+	now := time.Now()
+	premium := &Group{ID: "premium", queuedAt: now, priority: 5}
+	old := &Group{ID: "old", queuedAt: now.Add(-time.Minute)}
+	mm := newPriorityTestMatchmaker(&config.MatchmakerConfig{}, premium, old)
+
+	// Premium group couldn't be matched, but it seeds first again when another group may complete its match
+	want := []string{"premium", "old"}
+	for i, id := range want {
+		seed := mm.selectSeed()
+		if seed == nil || seed.ID != id {
+			t.Fatalf("seed %d: got %v, want %s", i, seed, id)
+		}
+	}
+	mm.returnGroupToSearch(&Group{ID: "new", queuedAt: now})
+	if seed := mm.selectSeed(); seed == nil || seed.ID != "premium" {
+		t.Fatalf("got %v, want premium", seed)
+	}
+
+	// Matched group doesn't seed anymore
+	mm.removeGroupFromSearch(premium)
+	for _, id := range []string{"old", "new", "old"} {
+		if seed := mm.selectSeed(); seed == nil || seed.ID != id {
+			t.Fatalf("got %v, want %s", seed, id)
+		}
+	}
+}
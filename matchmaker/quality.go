@@ -0,0 +1,182 @@
+package matchmaker
+
+import (
+	"goplay/config"
+
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type Match struct {
+	// Random ID to correlate logs, traces and server allocation
+	ID      string
+	Teams   []Team
+	Quality MatchQuality
+	// Streak compensation applied to matchmaking rating of players, by player ID
+	RatingAdjustments map[int]int
+}
+
+type MatchQuality struct {
+	// Difference between the highest and the lowest average rating of teams
+	RatingSpread int `json:"ratingSpread"`
+	// Difference between the largest parties of teams
+	PartySizeDelta int `json:"partySizeDelta"`
+	// From 0 to 1, 1 means teams are equal by rating and party composition
+	Score float64 `json:"score"`
+}
+
+func newMatch(teams []Team, params *config.MatchmakerConfig) *Match {
+	match := &Match{ID: newMatchID(), Teams: teams}
+	match.Quality.RatingSpread = teamsRatingSpread(teams)
+	match.Quality.PartySizeDelta = partySizeDelta(teams)
+
+	ratingScore := 1.0
+	if params.MaxRatingSpreadToSearch > 0 {
+		ratingScore -= float64(match.Quality.RatingSpread) / float64(params.MaxRatingSpreadToSearch)
+	}
+	partyScore := 1.0
+	if maxSize := maxTeamSize(teams); maxSize > 1 {
+		partyScore -= float64(match.Quality.PartySizeDelta) / float64(maxSize-1)
+	}
+	match.Quality.Score = clamp(ratingScore, 0, 1) * clamp(partyScore, 0, 1)
+
+	for _, team := range teams {
+		for _, group := range team.groups {
+			for _, player := range group.Players {
+				if player.ratingAdjustment == 0 {
+					continue
+				}
+				if match.RatingAdjustments == nil {
+					match.RatingAdjustments = make(map[int]int)
+				}
+				match.RatingAdjustments[player.ID] = player.ratingAdjustment
+			}
+		}
+	}
+
+	return match
+}
+
+func newMatchID() string {
+	id := make([]byte, 16)
+	// Never fails on supported platforms
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (t *Team) avgRating() int {
+	sum := 0
+	for _, group := range t.groups {
+		sum += group.SumRating
+	}
+	if t.numPlayers == 0 {
+		return 0
+	}
+
+	return sum / t.numPlayers
+}
+
+func (t *Team) largestParty() int {
+	largest := 0
+	for _, group := range t.groups {
+		if group.Size > largest {
+			largest = group.Size
+		}
+	}
+
+	return largest
+}
+
+func teamsRatingSpread(teams []Team) int {
+	if len(teams) == 0 {
+		return 0
+	}
+
+	min, max := teams[0].avgRating(), teams[0].avgRating()
+	for i := range teams[1:] {
+		rating := teams[i+1].avgRating()
+		if rating < min {
+			min = rating
+		}
+		if rating > max {
+			max = rating
+		}
+	}
+
+	return max - min
+}
+
+func partySizeDelta(teams []Team) int {
+	if len(teams) == 0 {
+		return 0
+	}
+
+	min, max := teams[0].largestParty(), teams[0].largestParty()
+	for i := range teams[1:] {
+		largest := teams[i+1].largestParty()
+		if largest < min {
+			min = largest
+		}
+		if largest > max {
+			max = largest
+		}
+	}
+
+	return max - min
+}
+
+func maxTeamSize(teams []Team) int {
+	max := 0
+	for _, team := range teams {
+		if team.size > max {
+			max = team.size
+		}
+	}
+
+	return max
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+
+	return v
+}
+
+// Checks that parties of opposing teams are comparable, e.g. a full premade doesn't face solo players.
+// Rules relax each SecondsToRelax the seed group waits: allowed delta, if set, grows by one and
+// full premades may face other teams.
+func (m *matchmaker) checkPartyFairness(teams []Team, seed *Group) bool {
+	fairness := m.params.PartyFairness
+	if fairness == nil {
+		return true
+	}
+
+	relaxSteps := 0
+	if fairness.SecondsToRelax > 0 {
+		relaxSteps = int(time.Since(seed.queuedAt) / (time.Duration(fairness.SecondsToRelax) * time.Second))
+	}
+
+	if fairness.MaxPartySizeDelta != nil && partySizeDelta(teams) > *fairness.MaxPartySizeDelta+relaxSteps {
+		return false
+	}
+
+	if fairness.FullPremadesOnly && relaxSteps == 0 {
+		premades := 0
+		for _, team := range teams {
+			if team.largestParty() == team.size {
+				premades++
+			}
+		}
+		if premades > 0 && premades < len(teams) {
+			return false
+		}
+	}
+
+	return true
+}
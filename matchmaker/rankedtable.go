@@ -1,42 +1,335 @@
 package matchmaker
 
-// Contains rating and groups with this rating.
-// Add O(1), Delete O(k), Get O(k),
-// where k is the number of groups with similar rating.
-type RankedGroupsTable map[int][]*Group
+// Contains groups sorted by rating, separately for each group size.
+// Add, Delete, Get O(log n + k), nearest group and range queries O(log n + m),
+// where k is the number of groups with the same rating and m is the number of visited groups.
+type RankedGroupsTable struct {
+	bySize map[int]*ratingIndex
+	length int
+}
+
+func NewRankedGroupsTable() *RankedGroupsTable {
+	return &RankedGroupsTable{
+		bySize: make(map[int]*ratingIndex),
+	}
+}
 
 func (t *RankedGroupsTable) Add(group *Group) {
-	groups, ok := (*t)[group.AvgRating]
+	index, ok := t.bySize[group.Size]
 	if !ok {
-		groups = make([]*Group, 1)
-		groups[0] = group
-	} else {
-		groups = append(groups, group)
+		index = newRatingIndex()
+		t.bySize[group.Size] = index
 	}
-	(*t)[group.AvgRating] = groups
+
+	index.add(group)
+	t.length++
 }
 
 func (t *RankedGroupsTable) Delete(group *Group) {
-	groups, ok := (*t)[group.AvgRating]
-	if ok {
-		index := -1
-		for i, g := range groups {
-			if groupsEqual(g, group) {
-				index = i
+	index, ok := t.bySize[group.Size]
+	if ok && index.delete(group) {
+		t.length--
+	}
+}
+
+// Returns groups of any size with exactly this rating
+func (t *RankedGroupsTable) Get(rating int) ([]*Group, bool) {
+	var groups []*Group
+	for _, index := range t.bySize {
+		node := index.seek(rating, nil)
+		if node != nil && node.rating == rating {
+			groups = append(groups, node.groups...)
+		}
+	}
+
+	return groups, len(groups) > 0
+}
+
+// Number of groups in the table
+func (t *RankedGroupsTable) Len() int {
+	return t.length
+}
+
+// Returns group closest by rating to the given one, for which accept returns true.
+// Only groups of size up to maxSize and rating closer than maxDistance are considered.
+func (t *RankedGroupsTable) Nearest(rating, maxDistance, maxSize int, accept func(g *Group) bool) *Group {
+	var nearest *Group
+	t.visitNearest(rating, maxDistance, maxSize, func(g *Group) bool {
+		if accept(g) {
+			nearest = g
+		}
+		return nearest == nil
+	})
+	return nearest
+}
+
+// Returns up to limit groups closest by rating to the given one, for which accept returns true,
+// closer groups first. Same groups as in Nearest are considered.
+func (t *RankedGroupsTable) NearestN(rating, maxDistance, maxSize, limit int, accept func(g *Group) bool) []*Group {
+	groups := make([]*Group, 0, limit)
+	if limit <= 0 {
+		return groups
+	}
+	t.visitNearest(rating, maxDistance, maxSize, func(g *Group) bool {
+		if accept(g) {
+			groups = append(groups, g)
+		}
+		return len(groups) < limit
+	})
+	return groups
+}
+
+// Calls fn for groups in order of distance from the rating until fn returns false
+func (t *RankedGroupsTable) visitNearest(rating, maxDistance, maxSize int, fn func(g *Group) bool) {
+	cursors := make([]nearestCursor, 0, len(t.bySize))
+	for size, index := range t.bySize {
+		if size <= maxSize {
+			cursors = append(cursors, index.nearest(rating))
+		}
+	}
+
+	for {
+		closest, distance := -1, 0
+		for i := range cursors {
+			d := cursors[i].distance()
+			if d < maxDistance && (closest < 0 || d < distance) {
+				closest, distance = i, d
+			}
+		}
+		if closest < 0 {
+			return
+		}
+
+		for _, group := range cursors[closest].node().groups {
+			if !fn(group) {
+				return
+			}
+		}
+		cursors[closest].advance()
+	}
+}
+
+// Calls fn for groups of any size with rating in [min, max] in ascending order of rating
+// until fn returns false
+func (t *RankedGroupsTable) Range(min, max int, fn func(g *Group) bool) {
+	nodes := make([]*ratingNode, 0, len(t.bySize))
+	for _, index := range t.bySize {
+		node := index.seek(min, nil)
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	for {
+		lowest := -1
+		for i, node := range nodes {
+			if node != nil && node.rating <= max && (lowest < 0 || node.rating < nodes[lowest].rating) {
+				lowest = i
 			}
 		}
+		if lowest < 0 {
+			return
+		}
 
-		if index >= 0 {
-			(*t)[group.AvgRating] = append((*t)[group.AvgRating][:index], (*t)[group.AvgRating][index+1:]...)
+		for _, group := range nodes[lowest].groups {
+			if !fn(group) {
+				return
+			}
 		}
+		nodes[lowest] = nodes[lowest].next[0]
 	}
 }
 
-func (t *RankedGroupsTable) Get(rating int) ([]*Group, bool) {
-	groups, ok := (*t)[rating]
-	if ok {
-		return groups, true
+const (
+	maxSkipListLevel = 24
+	// Probability of node having the next level is 1/skipListBranching
+	skipListBranching = 4
+)
+
+type ratingNode struct {
+	rating int
+	groups []*Group
+	prev   *ratingNode
+	next   []*ratingNode
+}
+
+// Skip list of groups keyed by rating
+type ratingIndex struct {
+	head  *ratingNode
+	level int
+	seed  uint64
+}
+
+func newRatingIndex() *ratingIndex {
+	return &ratingIndex{
+		head:  &ratingNode{next: make([]*ratingNode, maxSkipListLevel)},
+		level: 1,
+		seed:  0x9E3779B97F4A7C15,
+	}
+}
+
+// Returns the first node with rating >= given one.
+// If update is not nil, it's filled with the last nodes before it on each level.
+func (s *ratingIndex) seek(rating int, update []*ratingNode) *ratingNode {
+	node := s.head
+	for level := s.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].rating < rating {
+			node = node.next[level]
+		}
+		if update != nil {
+			update[level] = node
+		}
+	}
+
+	return node.next[0]
+}
+
+func (s *ratingIndex) add(group *Group) {
+	update := make([]*ratingNode, maxSkipListLevel)
+	node := s.seek(group.AvgRating, update)
+	if node != nil && node.rating == group.AvgRating {
+		node.groups = append(node.groups, group)
+		return
+	}
+
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	node = &ratingNode{
+		rating: group.AvgRating,
+		groups: []*Group{group},
+		next:   make([]*ratingNode, level),
+	}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+
+	if update[0] != s.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	}
+}
+
+func (s *ratingIndex) delete(group *Group) bool {
+	update := make([]*ratingNode, maxSkipListLevel)
+	node := s.seek(group.AvgRating, update)
+	if node == nil || node.rating != group.AvgRating {
+		return false
+	}
+
+	index := -1
+	for i, g := range node.groups {
+		if groupsEqual(g, group) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return false
+	}
+
+	node.groups = append(node.groups[:index], node.groups[index+1:]...)
+	if len(node.groups) > 0 {
+		return true
+	}
+
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+
+	return true
+}
+
+func (s *ratingIndex) nearest(rating int) nearestCursor {
+	hi := s.seek(rating, nil)
+	var lo *ratingNode
+	if hi != nil {
+		lo = hi.prev
+	} else {
+		lo = s.last()
+	}
+
+	return nearestCursor{rating: rating, lo: lo, hi: hi}
+}
+
+func (s *ratingIndex) last() *ratingNode {
+	node := s.head
+	for level := s.level - 1; level >= 0; level-- {
+		for node.next[level] != nil {
+			node = node.next[level]
+		}
+	}
+
+	if node == s.head {
+		return nil
+	}
+
+	return node
+}
+
+// xorshift is enough to balance the skip list and is cheaper than math/rand
+func (s *ratingIndex) randomLevel() int {
+	level := 1
+	for level < maxSkipListLevel {
+		s.seed ^= s.seed << 13
+		s.seed ^= s.seed >> 7
+		s.seed ^= s.seed << 17
+		if s.seed%skipListBranching != 0 {
+			break
+		}
+		level++
+	}
+
+	return level
+}
+
+// Iterates nodes of a rating index in order of distance from the rating
+type nearestCursor struct {
+	rating int
+	lo, hi *ratingNode
+}
+
+func (c *nearestCursor) distance() int {
+	const infinity = int(^uint(0) >> 1)
+	lo, hi := infinity, infinity
+	if c.lo != nil {
+		lo = c.rating - c.lo.rating
+	}
+	if c.hi != nil {
+		hi = c.hi.rating - c.rating
+	}
+	if lo < hi {
+		return lo
+	}
+
+	return hi
+}
+
+func (c *nearestCursor) node() *ratingNode {
+	if c.lo != nil && (c.hi == nil || c.rating-c.lo.rating < c.hi.rating-c.rating) {
+		return c.lo
+	}
+
+	return c.hi
+}
+
+func (c *nearestCursor) advance() {
+	if c.node() == c.lo {
+		c.lo = c.lo.prev
 	} else {
-		return nil, false
+		c.hi = c.hi.next[0]
 	}
 }
@@ -1,11 +1,15 @@
 package matchmaker
 
 import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 func TestAddSameRating(t *testing.T) {
-	rankedTable := make(RankedGroupsTable)
+	rankedTable := NewRankedGroupsTable()
 
 	group1 := Group{ID: "1", AvgRating: 10}
 	group2 := Group{ID: "2", AvgRating: 10}
@@ -15,13 +19,18 @@ func TestAddSameRating(t *testing.T) {
 	rankedTable.Add(&group2)
 	rankedTable.Add(&group3)
 
-	if len(rankedTable) != 2 {
-		t.Errorf("got %d, want %d", len(rankedTable), 2)
+	if rankedTable.Len() != 3 {
+		t.Errorf("got %d, want %d", rankedTable.Len(), 3)
+	}
+
+	g, _ := rankedTable.Get(10)
+	if len(g) != 2 {
+		t.Errorf("got %d, want %d", len(g), 2)
 	}
 }
 
 func TestDeleteSameRating(t *testing.T) {
-	rankedTable := make(RankedGroupsTable)
+	rankedTable := NewRankedGroupsTable()
 
 	group1 := Group{ID: "1", AvgRating: 10}
 	group2 := Group{ID: "2", AvgRating: 10}
@@ -31,18 +40,23 @@ func TestDeleteSameRating(t *testing.T) {
 
 	rankedTable.Delete(&group2)
 
-	if len(rankedTable) != 1 {
-		t.Errorf("got %d, want %d", len(rankedTable), 1)
+	if rankedTable.Len() != 1 {
+		t.Errorf("got %d, want %d", rankedTable.Len(), 1)
 	}
 
-	elem := rankedTable[10]
+	elem, _ := rankedTable.Get(10)
 	if elem[0].ID != "1" {
 		t.Errorf("got %s, want %d", elem[0].ID, 1)
 	}
+
+	rankedTable.Delete(&group1)
+	if _, ok := rankedTable.Get(10); ok {
+		t.Errorf("got group with rating %d after deleting all", 10)
+	}
 }
 
 func TestGet(t *testing.T) {
-	rankedTable := make(RankedGroupsTable)
+	rankedTable := NewRankedGroupsTable()
 
 	group1 := Group{ID: "1", AvgRating: 10}
 	group2 := Group{ID: "2", AvgRating: 10}
@@ -55,3 +69,163 @@ func TestGet(t *testing.T) {
 		t.Errorf("got %d, want %d", len(g), 2)
 	}
 }
+
+func TestNearest(t *testing.T) {
+	rankedTable := NewRankedGroupsTable()
+
+	rankedTable.Add(&Group{ID: "1", AvgRating: 90, Size: 1})
+	rankedTable.Add(&Group{ID: "2", AvgRating: 104, Size: 1})
+	rankedTable.Add(&Group{ID: "3", AvgRating: 101, Size: 3})
+	rankedTable.Add(&Group{ID: "4", AvgRating: 97, Size: 2})
+
+	acceptAll := func(g *Group) bool { return true }
+	tests := []struct {
+		rating, maxDistance, maxSize int
+		want                         string
+	}{
+		{100, 10, 5, "3"},
+		{100, 10, 2, "4"},
+		{100, 10, 1, "2"},
+		{100, 3, 1, ""},
+		{80, 10, 5, ""},
+		{80, 11, 5, "1"},
+		{120, 20, 5, "2"},
+		{0, math.MaxInt, 1, "1"},
+	}
+
+	for _, test := range tests {
+		got := ""
+		if g := rankedTable.Nearest(test.rating, test.maxDistance, test.maxSize, acceptAll); g != nil {
+			got = g.ID
+		}
+		if got != test.want {
+			t.Errorf("nearest to %d: got %q, want %q", test.rating, got, test.want)
+		}
+	}
+
+	notFirst := func(g *Group) bool { return g.ID != "3" }
+	if g := rankedTable.Nearest(100, 10, 5, notFirst); g == nil || g.ID != "4" {
+		t.Errorf("got %v, want group 4", g)
+	}
+}
+
+func TestNearestN(t *testing.T) {
+	rankedTable := NewRankedGroupsTable()
+
+	rankedTable.Add(&Group{ID: "1", AvgRating: 90, Size: 1})
+	rankedTable.Add(&Group{ID: "2", AvgRating: 104, Size: 1})
+	rankedTable.Add(&Group{ID: "3", AvgRating: 101, Size: 3})
+	rankedTable.Add(&Group{ID: "4", AvgRating: 97, Size: 2})
+
+	notFirst := func(g *Group) bool { return g.ID != "3" }
+	tests := []struct {
+		maxDistance, maxSize, limit int
+		want                        string
+	}{
+		{20, 5, 10, "4 2 1"},
+		{20, 5, 2, "4 2"},
+		{10, 5, 10, "4 2"},
+		{20, 1, 10, "2 1"},
+		{20, 5, 0, ""},
+	}
+
+	for _, test := range tests {
+		ids := []string{}
+		for _, g := range rankedTable.NearestN(100, test.maxDistance, test.maxSize, test.limit, notFirst) {
+			ids = append(ids, g.ID)
+		}
+		if got := strings.Join(ids, " "); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test, got, test.want)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	rankedTable := NewRankedGroupsTable()
+	for i := 0; i < 100; i++ {
+		rankedTable.Add(&Group{ID: strconv.Itoa(i), AvgRating: (i * 37) % 100, Size: i%5 + 1})
+	}
+
+	prev := -1
+	count := 0
+	rankedTable.Range(20, 29, func(g *Group) bool {
+		if g.AvgRating < prev || g.AvgRating < 20 || g.AvgRating > 29 {
+			t.Errorf("got rating %d after %d", g.AvgRating, prev)
+		}
+		prev = g.AvgRating
+		count++
+		return true
+	})
+
+	if count != 10 {
+		t.Errorf("got %d, want %d", count, 10)
+	}
+}
+
+const benchmarkGroups = 100000
+
+func newBenchmarkTable() (*RankedGroupsTable, []*Group) {
+	r := rand.New(rand.NewSource(1))
+	rankedTable := NewRankedGroupsTable()
+	groups := make([]*Group, benchmarkGroups)
+	for i := range groups {
+		groups[i] = &Group{ID: strconv.Itoa(i), AvgRating: r.Intn(3000), Size: r.Intn(5) + 1}
+		rankedTable.Add(groups[i])
+	}
+
+	return rankedTable, groups
+}
+
+func BenchmarkAdd(b *testing.B) {
+	rankedTable, _ := newBenchmarkTable()
+	r := rand.New(rand.NewSource(2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rankedTable.Add(&Group{ID: strconv.Itoa(i), AvgRating: r.Intn(3000), Size: r.Intn(5) + 1})
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	rankedTable, groups := newBenchmarkTable()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		group := groups[i%len(groups)]
+		rankedTable.Delete(group)
+		rankedTable.Add(group)
+	}
+}
+
+func BenchmarkNearest(b *testing.B) {
+	rankedTable, _ := newBenchmarkTable()
+	r := rand.New(rand.NewSource(3))
+	acceptAll := func(g *Group) bool { return true }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rankedTable.Nearest(r.Intn(3000), 100, 5, acceptAll)
+	}
+}
+
+func BenchmarkNearestSparse(b *testing.B) {
+	rankedTable, _ := newBenchmarkTable()
+	r := rand.New(rand.NewSource(4))
+	// Only one of 1000 groups is accepted
+	rare := func(g *Group) bool { return strings.HasSuffix(g.ID, "777") }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rankedTable.Nearest(r.Intn(3000), 100, 5, rare)
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	rankedTable, _ := newBenchmarkTable()
+	r := rand.New(rand.NewSource(5))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		min := r.Intn(3000)
+		count := 0
+		rankedTable.Range(min, min+10, func(g *Group) bool {
+			count++
+			return count < 100
+		})
+	}
+}
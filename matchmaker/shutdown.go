@@ -0,0 +1,47 @@
+package matchmaker
+
+import (
+	"context"
+)
+
+// Stops taking groups and making matches, then waits until matches in ready check
+// or server allocation are finished or context is done.
+// Groups left in search are kept in the state store, if it persists them, and cancelled for maintenance otherwise.
+func (m *matchmaker) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.state = QueueDraining
+	m.stopped = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.inFlight.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		m.logger.Warn("matches in progress are not finished before shutdown")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reason := SuspendedForRestart
+	if !m.store.Persistent() {
+		reason = CancelledForMaintenance
+	}
+	groups := m.searchQueue.Len()
+	for m.searchQueue.Len() > 0 {
+		group := m.searchQueue.Front().Value.(*Group)
+		cancelGroup(group, reason)
+		m.removeGroupFromSearch(group)
+		delete(m.groupIDs, group.ID)
+	}
+	m.logger.Info("queue is shut down", "groups", groups, "reason", reason)
+
+	return err
+}
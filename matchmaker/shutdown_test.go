@@ -0,0 +1,77 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	repo := &repositorytest.Repository{Players: map[int]repository.PlayerInfo{
+		1: {ID: 1, Rating: 100},
+		2: {ID: 2, Rating: 100},
+		3: {ID: 3, Rating: 900},
+	}}
+	params := config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100, CheckReadiness: true, SecondsToAcceptMatch: 5}
+	started := make(chan string, 1)
+	mm := NewMatchmaker(repo, &config.Config{Matchmaker: params}, func(ctx context.Context, match *Match, sendTo string) (string, error) {
+		started <- match.ID
+		return "server", nil
+	}).(*matchmaker)
+
+	cancelled := make(chan CancelReason, 1)
+	for _, id := range []int{1, 2, 3} {
+		err := mm.AddGroup(context.Background(), string(rune('0'+id)), []int{id}, nil, make(chan string, 1), cancelled)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		mm.Run()
+		close(stopped)
+	}()
+	for mm.Stats(100, 0).Depth != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Players accept the match while the matchmaker is shutting down
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		mm.SetPlayerReady(1)
+		mm.SetPlayerReady(2)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := mm.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	default:
+		t.Error("match in ready check is not finished before shutdown")
+	}
+	select {
+	case reason := <-cancelled:
+		if reason != CancelledForMaintenance {
+			t.Errorf("got reason %s, want %s", reason, CancelledForMaintenance)
+		}
+	default:
+		t.Error("group left in search is not cancelled")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("matchmaking loop is not stopped")
+	}
+	if mm.Resume(); mm.State() != QueueDraining {
+		t.Errorf("got state %s after resume, want %s", mm.State(), QueueDraining)
+	}
+}
@@ -0,0 +1,175 @@
+package matchmaker
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Ticket of the group as it's kept in state store
+type TicketRecord struct {
+	ID        string           `json:"id"`
+	PlayerIDs []int            `json:"playerIds"`
+	Roles     map[int][]string `json:"roles,omitempty"`
+	QueuedAt  time.Time        `json:"queuedAt"`
+	Requeued  bool             `json:"requeued,omitempty"`
+	// ID of the match the ticket is in, empty while ticket is in search
+	MatchID string `json:"matchId,omitempty"`
+}
+
+type PenaltyRecord struct {
+	PlayerID int       `json:"playerId"`
+	At       time.Time `json:"at"`
+	// Penalty is not needed after this time, so store may drop it
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// State of the queue which survives restart
+type State struct {
+	Tickets   []TicketRecord
+	Penalties []PenaltyRecord
+}
+
+const (
+	RemovedMatched   = "matched"
+	RemovedCancelled = "cancelled"
+	RemovedDeclined  = "declined"
+	RemovedRejected  = "rejected"
+)
+
+// Journals state transitions of tickets, so queue can be restored after restart.
+// Store must be safe for concurrent use, most calls are made under lock of the matchmaker.
+type StateStore interface {
+	// Ticket is added to search or returned to it
+	TicketQueued(ticket TicketRecord) error
+	// Tickets are taken from search into the match, which waits for ready check or server
+	TicketsMatching(matchID string, ids []string) error
+	// Tickets leave the queue for the given reason
+	TicketsRemoved(ids []string, reason string) error
+	PlayerPenalized(penalty PenaltyRecord) error
+	Load() (*State, error)
+	// Reports if state survives restart of the process
+	Persistent() bool
+}
+
+// Replaces default store which keeps nothing
+func WithStateStore(store StateStore) Option {
+	return func(m *matchmaker) {
+		m.store = store
+	}
+}
+
+// Notified when tickets enter search or a match, e.g. to show their state to clients.
+// Calls are made under lock of the matchmaker, so they must not block.
+type TicketListener interface {
+	TicketQueued(id string)
+	TicketsMatching(matchID string, ids []string)
+	// Ticket is restored from the state store, channels are notified when it leaves the queue
+	TicketRestored(ticket TicketRecord, matchFound <-chan string, searchCancelled <-chan CancelReason)
+}
+
+func WithTicketListener(listener TicketListener) Option {
+	return func(m *matchmaker) {
+		m.listener = listener
+	}
+}
+
+type noopListener struct{}
+
+func (noopListener) TicketQueued(id string)                       {}
+func (noopListener) TicketsMatching(matchID string, ids []string) {}
+func (noopListener) TicketRestored(ticket TicketRecord, matchFound <-chan string, searchCancelled <-chan CancelReason) {
+}
+
+type noopStore struct{}
+
+func (noopStore) TicketQueued(ticket TicketRecord) error             { return nil }
+func (noopStore) TicketsMatching(matchID string, ids []string) error { return nil }
+func (noopStore) TicketsRemoved(ids []string, reason string) error   { return nil }
+func (noopStore) PlayerPenalized(penalty PenaltyRecord) error        { return nil }
+func (noopStore) Load() (*State, error)                              { return &State{}, nil }
+func (noopStore) Persistent() bool                                   { return false }
+
+// Restores penalties and tickets from the store with their original enqueue times.
+// Tickets of matches which didn't finish ready check or server allocation are returned to search.
+// Restored tickets are checked again, rejected ones are removed from the store.
+func (m *matchmaker) Restore(ctx context.Context) error {
+	state, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for _, penalty := range state.Penalties {
+		m.penalizedPlayers[penalty.PlayerID] = penalty.At
+	}
+	m.mu.Unlock()
+
+	sort.Slice(state.Tickets, func(i, j int) bool {
+		return state.Tickets[i].QueuedAt.Before(state.Tickets[j].QueuedAt)
+	})
+	restored := 0
+	for _, ticket := range state.Tickets {
+		err := m.restoreTicket(ctx, ticket)
+		if err != nil {
+			m.logger.Warn("ticket is not restored", "group_id", ticket.ID, "player_ids", ticket.PlayerIDs, "error", err)
+			m.journal(m.store.TicketsRemoved([]string{ticket.ID}, RemovedRejected))
+			continue
+		}
+		restored++
+	}
+	m.logger.Info("queue restored", "groups", restored, "penalties", len(state.Penalties))
+
+	return nil
+}
+
+func (m *matchmaker) restoreTicket(ctx context.Context, ticket TicketRecord) error {
+	group, playersInfo, err := m.newGroup(ctx, ticket.ID, ticket.PlayerIDs, ticket.Roles)
+	if err != nil {
+		return err
+	}
+
+	// Listener waits for these channels, so clients can read the ticket
+	group.matchFound = make(chan string, 1)
+	group.cancelSearch = make(chan CancelReason, 1)
+	group.queuedAt = ticket.QueuedAt
+	group.requeued = ticket.Requeued || ticket.MatchID != ""
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.enqueue(group, playersInfo)
+	if err != nil {
+		return err
+	}
+	m.listener.TicketRestored(ticketRecord(group), group.matchFound, group.cancelSearch)
+
+	return nil
+}
+
+func ticketRecord(group *Group) TicketRecord {
+	record := TicketRecord{
+		ID:        group.ID,
+		PlayerIDs: make([]int, len(group.Players)),
+		QueuedAt:  group.queuedAt,
+		Requeued:  group.requeued,
+	}
+	for i, player := range group.Players {
+		record.PlayerIDs[i] = player.ID
+		if len(player.roles) > 0 {
+			if record.Roles == nil {
+				record.Roles = make(map[int][]string)
+			}
+			record.Roles[player.ID] = player.roles
+		}
+	}
+
+	return record
+}
+
+// Logs failed write to the store, matchmaking goes on without it
+func (m *matchmaker) journal(err error) {
+	if err != nil {
+		m.logger.Error("failed to write state", "error", err)
+	}
+}
@@ -0,0 +1,82 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	noopStore
+	state   *State
+	removed map[string]string
+}
+
+func (s *testStore) TicketsRemoved(ids []string, reason string) error {
+	for _, id := range ids {
+		s.removed[id] = reason
+	}
+	return nil
+}
+
+func (s *testStore) Load() (*State, error) {
+	return s.state, nil
+}
+
+type testListener struct {
+	noopListener
+	restored []string
+}
+
+func (l *testListener) TicketRestored(ticket TicketRecord, matchFound <-chan string, searchCancelled <-chan CancelReason) {
+	l.restored = append(l.restored, ticket.ID)
+}
+
+func TestRestore(t *testing.T) {
+	queuedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	store := &testStore{
+		state: &State{
+			Tickets: []TicketRecord{
+				{ID: "matching", PlayerIDs: []int{2}, QueuedAt: queuedAt.Add(time.Second), MatchID: "m1"},
+				{ID: "searching", PlayerIDs: []int{1}, QueuedAt: queuedAt},
+				{ID: "penalized", PlayerIDs: []int{3}, QueuedAt: queuedAt},
+			},
+			Penalties: []PenaltyRecord{{PlayerID: 3, At: time.Now()}},
+		},
+		removed: make(map[string]string),
+	}
+	repo := &repositorytest.Repository{Players: map[int]repository.PlayerInfo{
+		1: {ID: 1, Rating: 100},
+		2: {ID: 2, Rating: 110},
+		3: {ID: 3, Rating: 120},
+	}}
+	params := config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 100, PenaltySeconds: 60}
+	listener := &testListener{}
+	mm := NewMatchmaker(repo, &config.Config{Matchmaker: params}, nil, WithStateStore(store), WithTicketListener(listener)).(*matchmaker)
+
+	err := mm.Restore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := mm.Stats(100, 10)
+	if len(stats.Oldest) != 2 {
+		t.Fatalf("got tickets %v, want 2 restored", stats.Oldest)
+	}
+	if stats.Oldest[0].ID != "searching" || !stats.Oldest[0].QueuedAt.Equal(queuedAt) || stats.Oldest[0].Requeued {
+		t.Errorf("got %v, want ticket searching with original enqueue time", stats.Oldest[0])
+	}
+	if stats.Oldest[1].ID != "matching" || !stats.Oldest[1].Requeued {
+		t.Errorf("got %v, want ticket of unfinished match returned to search", stats.Oldest[1])
+	}
+	if len(listener.restored) != 2 || listener.restored[0] != "searching" || listener.restored[1] != "matching" {
+		t.Errorf("got restored tickets %v, want searching and matching", listener.restored)
+	}
+	if store.removed["penalized"] != RemovedRejected {
+		t.Errorf("got removed tickets %v, want penalized one rejected", store.removed)
+	}
+}
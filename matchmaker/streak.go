@@ -0,0 +1,75 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+
+	"context"
+)
+
+// Loads recent results of players and adjusts their matchmaking rating by win or loss streak
+func applyStreakCompensation(ctx context.Context, repo repository.Repository, params *config.MatchmakerConfig, group *Group) error {
+	compensation := params.StreakCompensation
+	if compensation == nil {
+		return nil
+	}
+
+	ids := make([]int, len(group.Players))
+	for i, player := range group.Players {
+		ids[i] = player.ID
+	}
+
+	results, err := repo.GetRecentResults(ctx, ids, compensation.MatchesToCheck)
+	if err != nil {
+		return err
+	}
+
+	for i := range group.Players {
+		player := &group.Players[i]
+		recent := results[player.ID]
+		if len(recent) == 0 {
+			continue
+		}
+
+		player.wonLastMatch = recent[0]
+		player.streak = streak(recent)
+
+		length := player.streak
+		if length < 0 {
+			length = -length
+		}
+		if length < compensation.MinStreak {
+			continue
+		}
+
+		adjustment := compensation.RatingPerGame * length
+		if compensation.MaxAdjustment > 0 && adjustment > compensation.MaxAdjustment {
+			adjustment = compensation.MaxAdjustment
+		}
+		// Players on a loss streak are matched with weaker players and vice versa
+		if player.streak < 0 {
+			adjustment = -adjustment
+		}
+		player.ratingAdjustment = adjustment
+	}
+
+	return nil
+}
+
+// Number of the same results in a row, positive for wins and negative for losses.
+// Results are ordered from the latest.
+func streak(results []bool) int {
+	length := 0
+	for _, won := range results {
+		if won != results[0] {
+			break
+		}
+		length++
+	}
+
+	if !results[0] {
+		return -length
+	}
+
+	return length
+}
@@ -0,0 +1,71 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"testing"
+)
+
+func TestStreak(t *testing.T) {
+	tests := []struct {
+		results []bool
+		want    int
+	}{
+		{[]bool{true}, 1},
+		{[]bool{true, true, false, true}, 2},
+		{[]bool{false, false, false}, -3},
+		{[]bool{false, true}, -1},
+	}
+
+	for _, test := range tests {
+		if got := streak(test.results); got != test.want {
+			t.Errorf("%v: got %d, want %d", test.results, got, test.want)
+		}
+	}
+}
+
+func TestStreakCompensation(t *testing.T) {
+	repo := &repositorytest.Repository{RecentResults: map[int][]bool{
+		1: {false, false, false, false, false, true},
+		2: {true, true, true, false},
+		3: {true, false},
+	}}
+	mm := &matchmaker{
+		repository: repo,
+		params: &config.MatchmakerConfig{
+			StreakCompensation: &config.StreakCompensationConfig{
+				MatchesToCheck: 10,
+				MinStreak:      3,
+				RatingPerGame:  20,
+				MaxAdjustment:  80,
+			},
+		},
+	}
+
+	group := &Group{Players: []Player{{ID: 1, Rating: 1000}, {ID: 2, Rating: 1000}, {ID: 3, Rating: 1000}}}
+	if err := applyStreakCompensation(context.Background(), mm.repository, mm.params, group); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{-80, 60, 0}
+	for i, player := range group.Players {
+		if player.ratingAdjustment != want[i] {
+			t.Errorf("player %d: got adjustment %d, want %d", player.ID, player.ratingAdjustment, want[i])
+		}
+	}
+	if group.Players[0].wonLastMatch || !group.Players[1].wonLastMatch {
+		t.Errorf("last match results are not set")
+	}
+
+	group.calcRating()
+	if group.SumRating != 2980 {
+		t.Errorf("got sum rating %d, want %d", group.SumRating, 2980)
+	}
+
+	match := newMatch([]Team{{groups: []*Group{group}, numPlayers: 3, size: 3}}, mm.params)
+	if len(match.RatingAdjustments) != 2 || match.RatingAdjustments[1] != -80 {
+		t.Errorf("got adjustments %v, want them recorded for players 1 and 2", match.RatingAdjustments)
+	}
+}
@@ -0,0 +1,71 @@
+package matchmaker
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer of the current global provider, so provider can be replaced after start
+func tracer() trace.Tracer {
+	return otel.Tracer("goplay/matchmaker")
+}
+
+// Context with span of the request which added the group, spans of the ticket are its children
+func (g *Group) traceContext() context.Context {
+	if g.traceCtx == nil {
+		return context.Background()
+	}
+
+	return g.traceCtx
+}
+
+// Starts span in trace of each group of the teams
+func startGroupSpans(teams []Team, name string) []trace.Span {
+	var spans []trace.Span
+	for _, team := range teams {
+		for _, group := range team.groups {
+			_, span := tracer().Start(group.traceContext(), name, trace.WithAttributes(attribute.String("group.id", group.ID)))
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+// Remembers the group as considered by the current pass
+func (m *matchmaker) consider(group *Group) {
+	if m.consideredGroups != nil {
+		m.consideredGroups[group] = true
+	}
+}
+
+// Starts span in trace of each group considered by the pass of the seed, linked to the span of the pass.
+// Groups which were probed or released without a match get it too, so their traces show why they waited.
+func (m *matchmaker) traceConsideredGroups(seed *Group, pass trace.Span) {
+	link := trace.LinkFromContext(trace.ContextWithSpan(context.Background(), pass))
+	for group := range m.consideredGroups {
+		if group == seed {
+			continue
+		}
+		_, span := tracer().Start(group.traceContext(), "matchmaker.ConsideredForMatch", trace.WithLinks(link),
+			trace.WithAttributes(
+				attribute.String("group.id", group.ID),
+				attribute.String("seed.id", seed.ID),
+				attribute.Bool("selected", group.SelectedForMatch),
+			))
+		span.End()
+	}
+	m.consideredGroups = nil
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,115 @@
+package matchmaker
+
+import (
+	"goplay/config"
+	"goplay/repository"
+	"goplay/repository/repositorytest"
+
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTicketTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	repo := &repositorytest.Repository{Players: map[int]repository.PlayerInfo{
+		1: {ID: 1, Rating: 100},
+		2: {ID: 2, Rating: 100},
+	}}
+	params := config.MatchmakerConfig{TeamSize: 1, TeamCount: 2, MaxRatingSpreadToSearch: 10}
+	requested := make(chan trace.SpanContext, 1)
+	mm := NewMatchmaker(repo, &config.Config{Matchmaker: params}, func(ctx context.Context, match *Match, sendTo string) (string, error) {
+		requested <- trace.SpanContextFromContext(ctx)
+		return "server", nil
+	}).(*matchmaker)
+
+	// Spans of requests which added the groups
+	traces := make(map[string]trace.TraceID)
+	for _, id := range []int{1, 2} {
+		ctx, span := provider.Tracer("test").Start(context.Background(), "POST /teams")
+		err := mm.AddGroup(ctx, string(rune('0'+id)), []int{id}, nil, make(chan string, 1), make(chan CancelReason, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		span.End()
+		traces[string(rune('0'+id))] = span.SpanContext().TraceID()
+	}
+
+	mm.RunPass()
+	var request trace.SpanContext
+	select {
+	case request = <-requested:
+	case <-time.After(time.Second):
+		t.Fatal("server is not requested")
+	}
+	if request.TraceID() != traces["1"] && request.TraceID() != traces["2"] {
+		t.Errorf("server request is not in trace of any ticket")
+	}
+
+	spansByTrace := make(map[trace.TraceID][]string)
+	for _, span := range recorder.Ended() {
+		spansByTrace[span.SpanContext().TraceID()] = append(spansByTrace[span.SpanContext().TraceID()], span.Name())
+	}
+	for id, traceID := range traces {
+		names := make(map[string]bool)
+		for _, name := range spansByTrace[traceID] {
+			names[name] = true
+		}
+		if !names["matchmaker.AddGroup"] || !(names["matchmaker.MatchPass"] || names["matchmaker.ConsideredForMatch"]) {
+			t.Errorf("trace of ticket %s has spans %v, want AddGroup and matchmaking pass", id, spansByTrace[traceID])
+		}
+	}
+}
+
+func TestTraceOfReleasedGroups(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	// 3 players can't fill 2 teams of 2, so the pass probes groups and releases them
+	repo := &repositorytest.Repository{DefaultRating: 100}
+	params := config.MatchmakerConfig{TeamSize: 2, TeamCount: 2, MaxRatingSpreadToSearch: 10}
+	mm := newTestMatchmaker(&params, repo, nil)
+
+	traces := make(map[trace.TraceID]string)
+	for _, id := range []int{1, 2, 3} {
+		ctx, span := provider.Tracer("test").Start(context.Background(), "POST /teams")
+		err := mm.AddGroup(ctx, string(rune('0'+id)), []int{id}, nil, make(chan string, 1), make(chan CancelReason, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		span.End()
+		traces[span.SpanContext().TraceID()] = string(rune('0' + id))
+	}
+
+	mm.RunPass()
+	considered := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() != "matchmaker.ConsideredForMatch" {
+			continue
+		}
+		if _, ok := traces[span.SpanContext().TraceID()]; !ok {
+			t.Errorf("span of considered group is not in trace of any ticket")
+		}
+		if len(span.Links()) != 1 {
+			t.Errorf("got %d links of considered group, want link to the pass", len(span.Links()))
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "selected" && attr.Value.AsBool() {
+				t.Errorf("group is selected, want released after failed pass")
+			}
+		}
+		considered++
+	}
+	if considered != 2 {
+		t.Errorf("got %d considered groups, want 2 groups besides the seed", considered)
+	}
+}
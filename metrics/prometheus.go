@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"goplay/matchmaker"
+
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Width of rating bands used as label of wait time, e.g. "1000-1499"
+const ratingBandWidth = 500
+
+// Collects metrics of matchmaker and repository and exports them in Prometheus format
+type Prometheus struct {
+	registry          *prometheus.Registry
+	queueDepth        *prometheus.GaugeVec
+	ticketWait        *prometheus.HistogramVec
+	matchesCreated    *prometheus.CounterVec
+	readyChecks       *prometheus.CounterVec
+	allocationLatency *prometheus.HistogramVec
+	allocationErrors  *prometheus.CounterVec
+	passDuration      *prometheus.HistogramVec
+	failedSeeds       *prometheus.CounterVec
+	lookupLatency     *prometheus.HistogramVec
+	lookupErrors      *prometheus.CounterVec
+}
+
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "goplay_queue_depth",
+			Help: "Number of groups in search.",
+		}, []string{"queue"}),
+		ticketWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goplay_ticket_wait_seconds",
+			Help:    "Time groups waited in search before they got into a match.",
+			Buckets: []float64{1, 5, 10, 20, 30, 60, 120, 300, 600},
+		}, []string{"queue", "rating_band", "group_size"}),
+		matchesCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goplay_matches_created_total",
+			Help: "Number of matches assembled.",
+		}, []string{"queue"}),
+		readyChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goplay_ready_checks_total",
+			Help: "Number of finished ready checks by outcome.",
+		}, []string{"queue", "outcome"}),
+		allocationLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goplay_server_allocation_seconds",
+			Help:    "Latency of server allocation requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"queue"}),
+		allocationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goplay_server_allocation_failures_total",
+			Help: "Number of failed server allocation requests.",
+		}, []string{"queue"}),
+		passDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goplay_matchmaking_pass_seconds",
+			Help:    "Duration of matchmaking passes.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"queue"}),
+		failedSeeds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goplay_failed_seeds_total",
+			Help: "Number of matchmaking passes where the seed didn't get into a match.",
+		}, []string{"queue"}),
+		lookupLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goplay_repository_lookup_seconds",
+			Help:    "Latency of repository lookups.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		lookupErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goplay_repository_lookup_failures_total",
+			Help: "Number of failed repository lookups.",
+		}, []string{"method"}),
+	}
+
+	p.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		p.queueDepth, p.ticketWait, p.matchesCreated, p.readyChecks,
+		p.allocationLatency, p.allocationErrors, p.passDuration, p.failedSeeds,
+		p.lookupLatency, p.lookupErrors,
+	)
+
+	return p
+}
+
+// Handler of /metrics endpoint
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+func (p *Prometheus) Lookup(method string, latency time.Duration, err error) {
+	p.lookupLatency.WithLabelValues(method).Observe(latency.Seconds())
+	if err != nil {
+		p.lookupErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// Metrics of the queue to pass to its matchmaker
+func (p *Prometheus) Queue(name string) matchmaker.Metrics {
+	return &queueMetrics{p: p, queue: name}
+}
+
+type queueMetrics struct {
+	p     *Prometheus
+	queue string
+}
+
+func (q *queueMetrics) QueueDepth(depth int) {
+	q.p.queueDepth.WithLabelValues(q.queue).Set(float64(depth))
+}
+
+func (q *queueMetrics) TicketWaited(rating, size int, wait time.Duration) {
+	q.p.ticketWait.WithLabelValues(q.queue, ratingBand(rating), strconv.Itoa(size)).Observe(wait.Seconds())
+}
+
+func (q *queueMetrics) MatchCreated() {
+	q.p.matchesCreated.WithLabelValues(q.queue).Inc()
+}
+
+func (q *queueMetrics) ReadyCheckFinished(outcome string) {
+	q.p.readyChecks.WithLabelValues(q.queue, outcome).Inc()
+}
+
+func (q *queueMetrics) ServerAllocated(latency time.Duration, err error) {
+	q.p.allocationLatency.WithLabelValues(q.queue).Observe(latency.Seconds())
+	if err != nil {
+		q.p.allocationErrors.WithLabelValues(q.queue).Inc()
+	}
+}
+
+func (q *queueMetrics) MatchPass(duration time.Duration, seedFailed bool) {
+	q.p.passDuration.WithLabelValues(q.queue).Observe(duration.Seconds())
+	if seedFailed {
+		q.p.failedSeeds.WithLabelValues(q.queue).Inc()
+	}
+}
+
+func ratingBand(rating int) string {
+	band := rating / ratingBandWidth
+	if rating < 0 && rating%ratingBandWidth != 0 {
+		band--
+	}
+
+	return fmt.Sprintf("%d-%d", band*ratingBandWidth, (band+1)*ratingBandWidth-1)
+}
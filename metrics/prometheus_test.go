@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRatingBand(t *testing.T) {
+	tests := []struct {
+		rating int
+		want   string
+	}{
+		{0, "0-499"},
+		{1499, "1000-1499"},
+		{1500, "1500-1999"},
+		{-1, "-500--1"},
+	}
+
+	for _, test := range tests {
+		if got := ratingBand(test.rating); got != test.want {
+			t.Errorf("band of %d: got %q, want %q", test.rating, got, test.want)
+		}
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	ranked := p.Queue("ranked")
+	ranked.QueueDepth(3)
+	ranked.TicketWaited(1200, 2, 15*time.Second)
+	ranked.MatchCreated()
+	ranked.ServerAllocated(time.Millisecond, errors.New("unavailable"))
+	ranked.MatchPass(time.Millisecond, true)
+	p.Lookup("GetUsersById", time.Millisecond, nil)
+
+	if got := testutil.ToFloat64(p.queueDepth.WithLabelValues("ranked")); got != 3 {
+		t.Errorf("got depth %v, want %d", got, 3)
+	}
+	if got := testutil.ToFloat64(p.allocationErrors.WithLabelValues("ranked")); got != 1 {
+		t.Errorf("got %v allocation failures, want %d", got, 1)
+	}
+	if got := testutil.ToFloat64(p.failedSeeds.WithLabelValues("ranked")); got != 1 {
+		t.Errorf("got %v failed seeds, want %d", got, 1)
+	}
+
+	res := httptest.NewRecorder()
+	p.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body := res.Body.String()
+	for _, want := range []string{
+		`goplay_ticket_wait_seconds_count{group_size="2",queue="ranked",rating_band="1000-1499"} 1`,
+		`goplay_matches_created_total{queue="ranked"} 1`,
+		`goplay_repository_lookup_seconds_count{method="GetUsersById"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer of the current global provider, so provider can be replaced after start
+func tracer() trace.Tracer {
+	return otel.Tracer("goplay/repository")
+}
+
+// Receives latency of repository lookups by method name, err is not nil if lookup failed
+type Metrics interface {
+	Lookup(method string, latency time.Duration, err error)
+}
+
+type instrumentedRepository struct {
+	repository Repository
+	metrics    Metrics
+}
+
+// Wraps repository to measure latency of its lookups and trace them
+func NewInstrumentedRepository(repository Repository, metrics Metrics) Repository {
+	return &instrumentedRepository{
+		repository: repository,
+		metrics:    metrics,
+	}
+}
+
+func (r *instrumentedRepository) GetUsersById(ctx context.Context, ids []int) ([]PlayerInfo, error) {
+	ctx, span := r.startSpan(ctx, "GetUsersById", ids)
+	start := time.Now()
+	players, err := r.repository.GetUsersById(ctx, ids)
+	r.finish(ctx, span, "GetUsersById", start, err)
+	return players, err
+}
+
+func (r *instrumentedRepository) GetBlockLists(ctx context.Context, ids []int) (map[int][]int, error) {
+	ctx, span := r.startSpan(ctx, "GetBlockLists", ids)
+	start := time.Now()
+	blocked, err := r.repository.GetBlockLists(ctx, ids)
+	r.finish(ctx, span, "GetBlockLists", start, err)
+	return blocked, err
+}
+
+func (r *instrumentedRepository) GetRecentOpponents(ctx context.Context, ids []int, since time.Time) (map[int][]int, error) {
+	ctx, span := r.startSpan(ctx, "GetRecentOpponents", ids)
+	start := time.Now()
+	opponents, err := r.repository.GetRecentOpponents(ctx, ids, since)
+	r.finish(ctx, span, "GetRecentOpponents", start, err)
+	return opponents, err
+}
+
+func (r *instrumentedRepository) GetRecentResults(ctx context.Context, ids []int, limit int) (map[int][]bool, error) {
+	ctx, span := r.startSpan(ctx, "GetRecentResults", ids)
+	start := time.Now()
+	results, err := r.repository.GetRecentResults(ctx, ids, limit)
+	r.finish(ctx, span, "GetRecentResults", start, err)
+	return results, err
+}
+
+func (r *instrumentedRepository) SaveMatchResult(ctx context.Context, result MatchResult) error {
+	var ids []int
+	for _, team := range result.Teams {
+		ids = append(ids, team...)
+	}
+	ctx, span := r.startSpan(ctx, "SaveMatchResult", ids)
+	span.SetAttributes(attribute.String("match.id", result.MatchID))
+	start := time.Now()
+	err := r.repository.SaveMatchResult(ctx, result)
+	r.finish(ctx, span, "SaveMatchResult", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) startSpan(ctx context.Context, method string, ids []int) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "repository."+method, trace.WithAttributes(attribute.IntSlice("player.ids", ids)))
+}
+
+// Records latency of the lookup and ends its span
+func (r *instrumentedRepository) finish(ctx context.Context, span trace.Span, method string, start time.Time, err error) {
+	r.metrics.Lookup(method, time.Since(start), err)
+	if err != nil {
+		slog.WarnContext(ctx, "repository lookup failed", "method", method, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
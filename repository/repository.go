@@ -2,13 +2,43 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+var ErrMatchExists = errors.New("result of the match is already saved")
+
 type PlayerInfo struct {
 	ID     uint64
 	Rating int
+	Level  int
+	// Position in the leaderboard, 0 if player is not ranked
+	Rank int
+	// Priority level of player's ticket (e.g. premium or tournament), 0 for regular players
+	Priority    int
+	GamesPlayed int
+	// Likelihood that account is a smurf, from 0 to 1
+	SmurfScore float64
+	CreatedAt  time.Time
+}
+
+// Result of the played match, players of each team by whether the team won
+type MatchResult struct {
+	MatchID  string
+	Teams    [][]int
+	Won      []bool
+	PlayedAt time.Time
 }
 
 type Repository interface {
 	GetUsersById(ctx context.Context, ids []int) ([]PlayerInfo, error)
+	// Players blocked or reported by each of the given players
+	GetBlockLists(ctx context.Context, ids []int) (map[int][]int, error)
+	// Opponents of each of the given players in matches played since the given time
+	GetRecentOpponents(ctx context.Context, ids []int, since time.Time) (map[int][]int, error)
+	// Results of the last matches of each of the given players from the latest, true if player won
+	GetRecentResults(ctx context.Context, ids []int, limit int) (map[int][]bool, error)
+	// Adds the match to history of its players, so later lookups of results and opponents include it.
+	// Returns ErrMatchExists if result of the match is already saved.
+	SaveMatchResult(ctx context.Context, result MatchResult) error
 }
@@ -0,0 +1,74 @@
+// Package repositorytest provides in-memory repository for tests of packages which read players and history
+package repositorytest
+
+import (
+	"goplay/repository"
+
+	"context"
+	"sync"
+	"time"
+)
+
+type Repository struct {
+	// Players by ID, players missing here have DefaultRating if it's set, otherwise they are not found
+	Players       map[int]repository.PlayerInfo
+	DefaultRating int
+	Blocked       map[int][]int
+	// Returned for any time, history is not filtered
+	RecentOpponents map[int][]int
+	RecentResults   map[int][]bool
+	// Receives saved match results if set
+	Results chan repository.MatchResult
+
+	mu    sync.Mutex
+	saved map[string]bool
+}
+
+func (r *Repository) GetUsersById(ctx context.Context, ids []int) ([]repository.PlayerInfo, error) {
+	var players []repository.PlayerInfo
+	for _, id := range ids {
+		if player, ok := r.Players[id]; ok {
+			players = append(players, player)
+		} else if r.DefaultRating != 0 {
+			players = append(players, repository.PlayerInfo{ID: uint64(id), Rating: r.DefaultRating})
+		}
+	}
+
+	return players, nil
+}
+
+func (r *Repository) GetBlockLists(ctx context.Context, ids []int) (map[int][]int, error) {
+	return r.Blocked, nil
+}
+
+func (r *Repository) GetRecentOpponents(ctx context.Context, ids []int, since time.Time) (map[int][]int, error) {
+	return r.RecentOpponents, nil
+}
+
+func (r *Repository) GetRecentResults(ctx context.Context, ids []int, limit int) (map[int][]bool, error) {
+	results := make(map[int][]bool)
+	for id, won := range r.RecentResults {
+		results[id] = won[:min(limit, len(won))]
+	}
+
+	return results, nil
+}
+
+func (r *Repository) SaveMatchResult(ctx context.Context, result repository.MatchResult) error {
+	r.mu.Lock()
+	if r.saved[result.MatchID] {
+		r.mu.Unlock()
+		return repository.ErrMatchExists
+	}
+	if r.saved == nil {
+		r.saved = make(map[string]bool)
+	}
+	r.saved[result.MatchID] = true
+	r.mu.Unlock()
+
+	if r.Results != nil {
+		r.Results <- result
+	}
+
+	return nil
+}
@@ -5,9 +5,17 @@ import (
 
 	"context"
 	"database/sql"
+	"errors"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Match history has a row for each pair of opponents of the match:
+//
+//	CREATE TABLE match_history (match_id TEXT NOT NULL, player_id INT NOT NULL, opponent_id INT NOT NULL,
+//		won BOOLEAN NOT NULL, played_at TIMESTAMP NOT NULL, UNIQUE (match_id, player_id, opponent_id))
+//	CREATE INDEX match_history_player ON match_history (player_id, played_at)
 type sqlRepository struct {
 	db *sql.DB
 }
@@ -27,18 +35,159 @@ func OpenDatabase(cfg *config.Config) (*sql.DB, error) {
 	return db, nil
 }
 
-func (r *sqlRepository) GetUsersById(ctx context.Context, ids []int) ([]PlayerInfo, error) {
+// Arguments and IN clause for the IDs, there must be at least one of them
+func inArgs(ids []int) ([]interface{}, string) {
 	args := make([]interface{}, len(ids))
 	for i, id := range ids {
 		args[i] = id
 	}
-	query := `SELECT id, rating from table2 WHERE id IN (?` + strings.Repeat(",?", len(args)-1) + `)`
+
+	return args, `(?` + strings.Repeat(",?", len(args)-1) + `)`
+}
+
+func (r *sqlRepository) GetUsersById(ctx context.Context, ids []int) ([]PlayerInfo, error) {
+	// IN clause needs at least one value
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args, in := inArgs(ids)
+	query := `SELECT id, rating, level, rank, priority, games_played, smurf_score, created_at from table2 WHERE id IN ` + in
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playersInfo := make([]PlayerInfo, 0, len(ids))
+	for rows.Next() {
+		var info PlayerInfo
+		err = rows.Scan(&info.ID, &info.Rating, &info.Level, &info.Rank, &info.Priority,
+			&info.GamesPlayed, &info.SmurfScore, &info.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		playersInfo = append(playersInfo, info)
+	}
+
+	return playersInfo, rows.Err()
+}
+
+func (r *sqlRepository) GetBlockLists(ctx context.Context, ids []int) (map[int][]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args, in := inArgs(ids)
+	query := `SELECT player_id, blocked_id from blocks WHERE player_id IN ` + in
+
+	return r.queryPlayerPairs(ctx, query, args...)
+}
+
+func (r *sqlRepository) GetRecentOpponents(ctx context.Context, ids []int, since time.Time) (map[int][]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args, in := inArgs(ids)
+	query := `SELECT player_id, opponent_id from match_history WHERE player_id IN ` + in + ` AND played_at >= ?`
+
+	return r.queryPlayerPairs(ctx, query, append(args, since)...)
+}
+
+func (r *sqlRepository) GetRecentResults(ctx context.Context, ids []int, limit int) (map[int][]bool, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args, in := inArgs(ids)
+	// History has a row for each opponent, so rows of the same match are merged before the limit is applied
+	query := `SELECT player_id, match_id, won FROM (
+		SELECT player_id, match_id, won, played_at,
+			ROW_NUMBER() OVER (PARTITION BY player_id ORDER BY played_at DESC, match_id) AS n
+		FROM (SELECT DISTINCT player_id, match_id, won, played_at FROM match_history WHERE player_id IN ` + in + `) AS matches
+	) AS recent WHERE n <= ? ORDER BY player_id, played_at DESC, match_id`
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[int][]bool)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var playerID int
+		var matchID string
+		var won bool
+		err = rows.Scan(&playerID, &matchID, &won)
+		if err != nil {
+			return nil, err
+		}
+		// Each match counts once for the player
+		key := strconv.Itoa(playerID) + "/" + matchID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(results[playerID]) < limit {
+			results[playerID] = append(results[playerID], won)
+		}
+	}
+
+	return results, rows.Err()
+}
+
+// Returns second column values grouped by the first column
+func (r *sqlRepository) queryPlayerPairs(ctx context.Context, query string, args ...interface{}) (map[int][]int, error) {
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	playersInfo := make([]PlayerInfo, len(ids))
-	rows.Scan(&playersInfo)
+	defer rows.Close()
+
+	pairs := make(map[int][]int)
+	for rows.Next() {
+		var playerID, otherID int
+		err = rows.Scan(&playerID, &otherID)
+		if err != nil {
+			return nil, err
+		}
+		pairs[playerID] = append(pairs[playerID], otherID)
+	}
+
+	return pairs, rows.Err()
+}
+
+// History has a row for each pair of opponents, as it's read by GetRecentOpponents
+func (r *sqlRepository) SaveMatchResult(ctx context.Context, result MatchResult) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Retried reports would count the match twice, concurrent ones fail on the unique key
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM match_history WHERE match_id = ? LIMIT 1`, result.MatchID).Scan(&exists)
+	if err == nil {
+		return ErrMatchExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query := `INSERT INTO match_history (match_id, player_id, opponent_id, won, played_at) VALUES (?, ?, ?, ?, ?)`
+	for i, team := range result.Teams {
+		for j, opponents := range result.Teams {
+			if i == j {
+				continue
+			}
+			for _, playerID := range team {
+				for _, opponentID := range opponents {
+					_, err = tx.ExecContext(ctx, query, result.MatchID, playerID, opponentID, result.Won[i], result.PlayedAt)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
 
-	return nil, nil
+	return tx.Commit()
 }
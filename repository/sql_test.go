@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Database which answers every query with the same rows and records executed statements
+type testDB struct {
+	columns []string
+	rows    [][]driver.Value
+	execs   []string
+}
+
+func (d *testDB) Connect(ctx context.Context) (driver.Conn, error) { return testConn{d}, nil }
+func (d *testDB) Driver() driver.Driver                            { return nil }
+
+type testConn struct{ db *testDB }
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) { return testStmt{c.db, query}, nil }
+func (c testConn) Close() error                              { return nil }
+func (c testConn) Begin() (driver.Tx, error)                 { return testTx{}, nil }
+
+type testTx struct{}
+
+func (testTx) Commit() error   { return nil }
+func (testTx) Rollback() error { return nil }
+
+type testStmt struct {
+	db    *testDB
+	query string
+}
+
+func (s testStmt) Close() error  { return nil }
+func (s testStmt) NumInput() int { return -1 }
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.execs = append(s.db.execs, s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &testRows{columns: s.db.columns, rows: s.db.rows}, nil
+}
+
+type testRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestRecentResultsByMatch(t *testing.T) {
+	// History of 2 vs 2 matches has a row for each opponent
+	db := &testDB{
+		columns: []string{"player_id", "match_id", "won"},
+		rows: [][]driver.Value{
+			{int64(1), "m3", false}, {int64(1), "m3", false},
+			{int64(1), "m2", true}, {int64(1), "m2", true},
+			{int64(1), "m1", false}, {int64(1), "m1", false},
+			{int64(2), "m3", false}, {int64(2), "m3", false},
+		},
+	}
+	repo := NewSQLRepository(sql.OpenDB(db))
+
+	results, err := repo.GetRecentResults(context.Background(), []int{1, 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[1]) != 2 || results[1][0] || !results[1][1] {
+		t.Errorf("got results %v of player 1, want loss and win of the last 2 matches", results[1])
+	}
+	if len(results[2]) != 1 {
+		t.Errorf("got results %v of player 2, want 1 match", results[2])
+	}
+}
+
+func TestSaveMatchResultOnce(t *testing.T) {
+	result := MatchResult{MatchID: "m1", Teams: [][]int{{1}, {2}}, Won: []bool{true, false}}
+
+	db := &testDB{columns: []string{"1"}}
+	if err := NewSQLRepository(sql.OpenDB(db)).SaveMatchResult(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.execs) != 2 {
+		t.Errorf("got %d rows inserted, want 2", len(db.execs))
+	}
+
+	// History already has rows of the match
+	db = &testDB{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}
+	err := NewSQLRepository(sql.OpenDB(db)).SaveMatchResult(context.Background(), result)
+	if !errors.Is(err, ErrMatchExists) || len(db.execs) != 0 {
+		t.Errorf("got %v and %d rows inserted, want %v and none", err, len(db.execs), ErrMatchExists)
+	}
+}
@@ -0,0 +1,263 @@
+package state
+
+import (
+	"goplay/matchmaker"
+
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	entryQueued    = "queued"
+	entryMatching  = "matching"
+	entryRemoved   = "removed"
+	entryPenalized = "penalized"
+
+	// Journal is compacted when it has this many more entries than the state it describes
+	compactThreshold = 1000
+)
+
+// Line of the journal
+type entry struct {
+	Type    string                    `json:"type"`
+	Ticket  *matchmaker.TicketRecord  `json:"ticket,omitempty"`
+	MatchID string                    `json:"matchId,omitempty"`
+	IDs     []string                  `json:"ids,omitempty"`
+	Reason  string                    `json:"reason,omitempty"`
+	Penalty *matchmaker.PenaltyRecord `json:"penalty,omitempty"`
+}
+
+// Keeps state of the queue in a journal file with one JSON entry per line.
+// Journal is replayed on open and rewritten with the current state when it grows.
+type FileStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	sync      bool
+	tickets   map[string]matchmaker.TicketRecord
+	penalties map[int]matchmaker.PenaltyRecord
+	entries   int
+}
+
+// Opens journal, creates it if it doesn't exist.
+// If sync is true, every entry is flushed to disk before the call returns.
+func OpenFileStore(path string, sync bool) (*FileStore, error) {
+	s := &FileStore{
+		path:      path,
+		sync:      sync,
+		tickets:   make(map[string]matchmaker.TicketRecord),
+		penalties: make(map[int]matchmaker.PenaltyRecord),
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+
+	err = s.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) replay() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var pending error
+	for line := 1; scanner.Scan(); line++ {
+		// Only the last entry may be broken, if process stopped while writing it
+		if pending != nil {
+			return pending
+		}
+
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			pending = fmt.Errorf("broken entry at line %d of journal %s: %w", line, s.path, err)
+			continue
+		}
+		s.apply(e)
+	}
+
+	return scanner.Err()
+}
+
+func (s *FileStore) apply(e entry) {
+	switch e.Type {
+	case entryQueued:
+		ticket := *e.Ticket
+		ticket.MatchID = ""
+		s.tickets[ticket.ID] = ticket
+	case entryMatching:
+		for _, id := range e.IDs {
+			if ticket, ok := s.tickets[id]; ok {
+				ticket.MatchID = e.MatchID
+				s.tickets[id] = ticket
+			}
+		}
+	case entryRemoved:
+		for _, id := range e.IDs {
+			delete(s.tickets, id)
+		}
+	case entryPenalized:
+		s.penalties[e.Penalty.PlayerID] = *e.Penalty
+	}
+}
+
+// Rewrites journal with the current state and opens it for appending
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	entries := 0
+	for _, ticket := range s.tickets {
+		ticket := ticket
+		matchID := ticket.MatchID
+		ticket.MatchID = ""
+		err = encoder.Encode(entry{Type: entryQueued, Ticket: &ticket})
+		if err == nil && matchID != "" {
+			err = encoder.Encode(entry{Type: entryMatching, MatchID: matchID, IDs: []string{ticket.ID}})
+			entries++
+		}
+		if err != nil {
+			break
+		}
+		entries++
+	}
+	// Expired penalties are dropped
+	now := time.Now()
+	for id, penalty := range s.penalties {
+		if !now.Before(penalty.ExpiresAt) {
+			delete(s.penalties, id)
+			continue
+		}
+		penalty := penalty
+		if err = encoder.Encode(entry{Type: entryPenalized, Penalty: &penalty}); err != nil {
+			break
+		}
+		entries++
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	s.entries = entries
+
+	return nil
+}
+
+func (s *FileStore) write(e entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if s.sync {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+
+	s.apply(e)
+	s.entries++
+	if s.entries > 2*(len(s.tickets)+len(s.penalties))+compactThreshold {
+		return s.compact()
+	}
+
+	return nil
+}
+
+func (s *FileStore) TicketQueued(ticket matchmaker.TicketRecord) error {
+	return s.write(entry{Type: entryQueued, Ticket: &ticket})
+}
+
+func (s *FileStore) TicketsMatching(matchID string, ids []string) error {
+	return s.write(entry{Type: entryMatching, MatchID: matchID, IDs: ids})
+}
+
+func (s *FileStore) TicketsRemoved(ids []string, reason string) error {
+	return s.write(entry{Type: entryRemoved, IDs: ids, Reason: reason})
+}
+
+func (s *FileStore) PlayerPenalized(penalty matchmaker.PenaltyRecord) error {
+	return s.write(entry{Type: entryPenalized, Penalty: &penalty})
+}
+
+func (s *FileStore) Load() (*matchmaker.State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := &matchmaker.State{}
+	for _, ticket := range s.tickets {
+		state.Tickets = append(state.Tickets, ticket)
+	}
+	for _, penalty := range s.penalties {
+		state.Penalties = append(state.Penalties, penalty)
+	}
+
+	return state, nil
+}
+
+func (s *FileStore) Persistent() bool {
+	return true
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
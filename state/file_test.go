@@ -0,0 +1,93 @@
+package state
+
+import (
+	"goplay/matchmaker"
+
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := OpenFileStore(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queuedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	store.TicketQueued(matchmaker.TicketRecord{ID: "1", PlayerIDs: []int{1}, QueuedAt: queuedAt})
+	store.TicketQueued(matchmaker.TicketRecord{ID: "2", PlayerIDs: []int{2, 3}, QueuedAt: queuedAt})
+	store.TicketQueued(matchmaker.TicketRecord{ID: "3", PlayerIDs: []int{4}, QueuedAt: queuedAt})
+	store.TicketsMatching("m1", []string{"1", "2"})
+	store.TicketsRemoved([]string{"3"}, matchmaker.RemovedCancelled)
+	store.PlayerPenalized(matchmaker.PenaltyRecord{PlayerID: 5, At: queuedAt, ExpiresAt: time.Now().Add(time.Minute)})
+	store.PlayerPenalized(matchmaker.PenaltyRecord{PlayerID: 6, At: queuedAt, ExpiresAt: time.Now()})
+	store.Close()
+
+	// Process stopped in the middle of writing an entry
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"type":"removed","ids":["1"`)
+	f.Close()
+
+	store, err = OpenFileStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	state, _ := store.Load()
+
+	tickets := make(map[string]matchmaker.TicketRecord)
+	for _, ticket := range state.Tickets {
+		tickets[ticket.ID] = ticket
+	}
+	if len(tickets) != 2 || tickets["1"].MatchID != "m1" || tickets["2"].MatchID != "m1" {
+		t.Errorf("got tickets %v, want 1 and 2 in match m1", state.Tickets)
+	}
+	if !tickets["2"].QueuedAt.Equal(queuedAt) || len(tickets["2"].PlayerIDs) != 2 {
+		t.Errorf("got ticket %v, want it as it was queued", tickets["2"])
+	}
+	if len(state.Penalties) != 1 || state.Penalties[0].PlayerID != 5 {
+		t.Errorf("got penalties %v, want only unexpired penalty of player 5", state.Penalties)
+	}
+}
+
+func TestFileStoreBrokenJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	os.WriteFile(path, []byte("{broken\n{\"type\":\"removed\",\"ids\":[\"1\"]}\n"), 0644)
+
+	_, err := OpenFileStore(path, false)
+	if err == nil {
+		t.Error("broken entry in the middle of journal is ignored")
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := OpenFileStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.TicketQueued(matchmaker.TicketRecord{ID: "kept", PlayerIDs: []int{1}})
+	for i := 0; i < 2*compactThreshold; i++ {
+		store.TicketQueued(matchmaker.TicketRecord{ID: "temp", PlayerIDs: []int{2}})
+		store.TicketsRemoved([]string{"temp"}, matchmaker.RemovedMatched)
+	}
+
+	if store.entries > compactThreshold+2 {
+		t.Errorf("got %d entries in journal, want it compacted", store.entries)
+	}
+
+	reopened, err := OpenFileStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	state, _ := reopened.Load()
+	if len(state.Tickets) != 1 || state.Tickets[0].ID != "kept" {
+		t.Errorf("got tickets %v, want only kept one", state.Tickets)
+	}
+}